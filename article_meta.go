@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kjk/u"
+)
+
+// Article metadata are "key: value" lines at the top of a notion page
+// or a .md file. metaFields is the schema of all keys we understand.
+// Keys are case-insensitive.
+//
+// Problems with metadata are not fatal while parsing. They're recorded in
+// Article.metaProblems so that -lint can report all of them at once.
+// A build fails if there are errors (but not if there are only warnings).
+
+type metaType int
+
+const (
+	metaString metaType = iota
+	metaDate
+	metaTags
+	metaEnum
+)
+
+type metaField struct {
+	key string
+	// deprecated names of the key. The key wins over them if both are used
+	aliases []string
+	typ     metaType
+	// required in articles that are part of the blog i.e. have publishedon.
+	// Other pages usually don't have metadata at all. Many old posts don't
+	// have tags so for now it's a warning, not an error
+	requiredInBlog bool
+	// valid values for metaEnum
+	allowed []string
+	// val has already been checked according to typ
+	set func(a *Article, val string) error
+}
+
+var metaFields = []*metaField{
+	{
+		key: "id",
+		set: func(a *Article, val string) error {
+			if val == "" {
+				return fmt.Errorf("is empty")
+			}
+			articleSetID(a, val)
+			return nil
+		},
+	},
+	{
+		key:            "tags",
+		typ:            metaTags,
+		requiredInBlog: true,
+		set: func(a *Article, val string) error {
+			a.Tags = parseTags(val)
+			return nil
+		},
+	},
+	{
+		key:     "publishedon",
+		aliases: []string{"date", "createdat"},
+		typ:     metaDate,
+		set: func(a *Article, val string) error {
+			a.PublishedOn, _ = parseDate(val)
+			a.inBlog = true
+			return nil
+		},
+	},
+	{
+		key: "updatedat",
+		typ: metaDate,
+		set: func(a *Article, val string) error {
+			a.UpdatedOn, _ = parseDate(val)
+			return nil
+		},
+	},
+	{
+		key:     "status",
+		typ:     metaEnum,
+		allowed: []string{"", "hidden", "notimportant", "deleted"},
+		set: func(a *Article, val string) error {
+			var err error
+			a.Status, err = parseStatus(val)
+			return err
+		},
+	},
+	{
+		key: "description",
+		set: func(a *Article, val string) error {
+			a.Description = val
+			return nil
+		},
+	},
+	{
+		key: "headerimage",
+		set: setHeaderImage,
+	},
+	{
+		key: "collection",
+		set: setCollection,
+	},
+	{
+		key:     "toc",
+		typ:     metaEnum,
+		allowed: []string{"", "yes", "no"},
+		set: func(a *Article, val string) error {
+			a.tocMode = strings.ToLower(strings.TrimSpace(val))
+			return nil
+		},
+	},
+	{
+		key: "url",
+		set: func(a *Article, val string) error {
+			if !strings.HasPrefix(val, "/") {
+				return fmt.Errorf("'%s' should start with /", val)
+			}
+			a.urlOverride = val
+			return nil
+		},
+	},
+}
+
+// metaProblem is a problem with article's metadata
+type metaProblem struct {
+	// warnings are reported by -lint but don't fail the build
+	isWarning bool
+	msg       string
+}
+
+func (p *metaProblem) String() string {
+	if p.isWarning {
+		return "warning: " + p.msg
+	}
+	return "error: " + p.msg
+}
+
+func (a *Article) addMetaProblem(isWarning bool, format string, args ...interface{}) {
+	p := &metaProblem{
+		isWarning: isWarning,
+		msg:       fmt.Sprintf(format, args...),
+	}
+	a.metaProblems = append(a.metaProblems, p)
+}
+
+// metaLine is a single "key: value" line
+type metaLine struct {
+	key string
+	val string
+}
+
+func findMetaField(key string) (*metaField, bool) {
+	key = strings.ToLower(key)
+	for _, f := range metaFields {
+		if f.key == key {
+			return f, false
+		}
+		for _, alias := range f.aliases {
+			if alias == key {
+				return f, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// keys are single words, which is how we tell metadata from a paragraph
+// that happens to have ':' in it (together with non-empty value, to not
+// confuse it with "Links:" paragraph)
+var metaKeyRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+func looksLikeMetaKey(key string) bool {
+	return metaKeyRegex.MatchString(key)
+}
+
+// checkMetaValue checks val according to field's type
+func checkMetaValue(f *metaField, val string) error {
+	switch f.typ {
+	case metaDate:
+		_, err := parseDate(val)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a date like 2006-01-02 or 2006-01-02T15:04:05Z", val)
+		}
+	case metaTags:
+		if len(parseTags(val)) == 0 {
+			return fmt.Errorf("has no tags")
+		}
+	case metaEnum:
+		v := strings.ToLower(strings.TrimSpace(val))
+		for _, s := range f.allowed {
+			if s == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("'%s' is not one of: %s", val, strings.Join(f.allowed[1:], ", "))
+	}
+	return nil
+}
+
+// applyArticleMeta sets article fields from metadata lines, in the order
+// of metaFields, and records problems in article.metaProblems
+func applyArticleMeta(article *Article, lines []metaLine) {
+	type fieldVal struct {
+		key     string
+		val     string
+		isAlias bool
+	}
+	vals := map[*metaField]*fieldVal{}
+	for _, l := range lines {
+		f, isAlias := findMetaField(l.key)
+		if f == nil {
+			article.addMetaProblem(true, "unknown key '%s'", l.key)
+			continue
+		}
+		if isAlias {
+			article.addMetaProblem(true, "'%s' is deprecated, use '%s'", l.key, f.key)
+		}
+		prev := vals[f]
+		if prev != nil {
+			if isAlias && !prev.isAlias {
+				article.addMetaProblem(true, "'%s' is ignored because '%s' is set", l.key, prev.key)
+				continue
+			}
+			if isAlias == prev.isAlias {
+				article.addMetaProblem(true, "'%s' is set more than once, using the last value", l.key)
+			}
+		}
+		vals[f] = &fieldVal{
+			key:     l.key,
+			val:     l.val,
+			isAlias: isAlias,
+		}
+	}
+
+	for _, f := range metaFields {
+		v := vals[f]
+		if v == nil {
+			continue
+		}
+		err := checkMetaValue(f, v.val)
+		if err == nil {
+			err = f.set(article, v.val)
+		}
+		if err != nil {
+			article.addMetaProblem(false, "'%s': %s", v.key, err)
+		}
+	}
+	// only now we know if it's a blog article
+	for _, f := range metaFields {
+		if f.requiredInBlog && article.inBlog && vals[f] == nil {
+			article.addMetaProblem(true, "'%s' is required in blog articles", f.key)
+		}
+	}
+}
+
+func parseTags(s string) []string {
+	tags := strings.Split(s, ",")
+	var res []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		tag = strings.ToLower(tag)
+		if tag == "" {
+			continue
+		}
+		// skip the tag I use in quicknotes.io to tag notes for the blog
+		if tag == "for-blog" || tag == "published" || tag == "draft" {
+			continue
+		}
+		res = append(res, tag)
+	}
+	return res
+}
+
+func parseDate(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	t, err = time.Parse("2006-01-02", s)
+	if err == nil {
+		return t, nil
+	}
+	// TODO: more formats?
+	return time.Now(), err
+}
+
+func parseStatus(status string) (int, error) {
+	status = strings.TrimSpace(strings.ToLower(status))
+	if status == "" {
+		return statusNormal, nil
+	}
+	switch status {
+	case "hidden":
+		return statusHidden, nil
+	case "notimportant":
+		return statusNotImportant, nil
+	case "deleted":
+		return statusDeleted, nil
+	default:
+		return 0, fmt.Errorf("'%s' is not a valid status", status)
+	}
+}
+
+func setCollection(article *Article, val string) error {
+	if site.isIgnoredCollection(val) {
+		return nil
+	}
+	coll := site.Collections[val]
+	if coll == nil {
+		return fmt.Errorf("'%s' is not a known collection", val)
+	}
+	article.Collection = coll.Title
+	article.CollectionURL = coll.URL
+	return nil
+}
+
+func setHeaderImage(article *Article, val string) error {
+	if val == "" {
+		return fmt.Errorf("is empty")
+	}
+	if val[0] != '/' {
+		val = "/" + val
+	}
+	path := filepath.Join("www", val)
+	if !u.FileExists(path) {
+		return fmt.Errorf("file '%s' doesn't exist", path)
+	}
+	article.HeaderImageURL = netlifyRequestGetFullHost() + val
+	return nil
+}
+
+// metaSourceDescription describes where article comes from, for lint messages
+func (a *Article) metaSourceDescription() string {
+	if a.page != nil {
+		id := normalizeID(a.page.ID)
+		return fmt.Sprintf("page %s '%s' https://notion.so/%s", id, a.Title, id)
+	}
+	return fmt.Sprintf("file %s '%s'", a.sourcePath, a.Title)
+}
+
+// formatMetaProblems returns a report of metadata problems of all articles,
+// and the number of errors
+func formatMetaProblems(articles []*Article, withWarnings bool) (string, int) {
+	var lines []string
+	nErrors := 0
+	for _, a := range articles {
+		var problems []*metaProblem
+		for _, p := range a.metaProblems {
+			if p.isWarning && !withWarnings {
+				continue
+			}
+			if !p.isWarning {
+				nErrors++
+			}
+			problems = append(problems, p)
+		}
+		if len(problems) == 0 {
+			continue
+		}
+		lines = append(lines, a.metaSourceDescription()+":")
+		for _, p := range problems {
+			lines = append(lines, "  "+p.String())
+		}
+	}
+	return strings.Join(lines, "\n"), nErrors
+}
+
+// lintArticles loads articles from all sources and prints problems with
+// their metadata. Returns false if there are errors
+func lintArticles(sources []ContentSource) bool {
+	var all []*Article
+	idToArticle := map[string]*Article{}
+	for _, source := range sources {
+		articles, err := source.LoadArticles()
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			return false
+		}
+		for _, a := range articles {
+			if existing := idToArticle[a.ID]; existing != nil {
+				a.addMetaProblem(false, "id '%s' is also used by %s", a.ID, existing.metaSourceDescription())
+			}
+			idToArticle[a.ID] = a
+			all = append(all, a)
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].metaSourceDescription() < all[j].metaSourceDescription()
+	})
+	report, nErrors := formatMetaProblems(all, true)
+	if report != "" {
+		fmt.Printf("%s\n", report)
+	}
+	nWarnings := 0
+	for _, a := range all {
+		nWarnings += len(a.metaProblems)
+	}
+	nWarnings -= nErrors
+	fmt.Printf("Checked %d articles: %d errors, %d warnings\n", len(all), nErrors, nWarnings)
+	return nErrors == 0
+}
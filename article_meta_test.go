@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func metaProblemsAsStrings(a *Article) []string {
+	var res []string
+	for _, p := range a.metaProblems {
+		res = append(res, p.String())
+	}
+	return res
+}
+
+func TestApplyArticleMeta(t *testing.T) {
+	a := &Article{}
+	applyArticleMeta(a, []metaLine{
+		{"Date", "2018-01-02"},
+		{"PublishedOn", "2019-03-04"},
+		{"CreatedAt", "2017-01-01"},
+		{"Tags", "Go, , programming"},
+		{"Status", "hidden"},
+		{"Status", "notimportant"},
+		{"Foo", "bar"},
+	})
+	assert.Equal(t, "2019-03-04", a.PublishedOn.Format("2006-01-02"))
+	assert.True(t, a.IsBlog())
+	assert.Equal(t, []string{"go", "programming"}, a.Tags)
+	assert.Equal(t, statusNotImportant, a.Status)
+	exp := []string{
+		"warning: 'Date' is deprecated, use 'publishedon'",
+		"warning: 'CreatedAt' is deprecated, use 'publishedon'",
+		"warning: 'CreatedAt' is ignored because 'PublishedOn' is set",
+		"warning: 'Status' is set more than once, using the last value",
+		"warning: unknown key 'Foo'",
+	}
+	assert.Equal(t, exp, metaProblemsAsStrings(a))
+}
+
+func TestApplyArticleMetaErrors(t *testing.T) {
+	a := &Article{}
+	applyArticleMeta(a, []metaLine{
+		{"publishedon", "March 4"},
+		{"status", "draft"},
+		{"url", "foo.html"},
+	})
+	exp := []string{
+		"error: 'publishedon': 'March 4' is not a date like 2006-01-02 or 2006-01-02T15:04:05Z",
+		"error: 'status': 'draft' is not one of: hidden, notimportant, deleted",
+		"error: 'url': 'foo.html' should start with /",
+	}
+	assert.Equal(t, exp, metaProblemsAsStrings(a))
+	report, nErrors := formatMetaProblems([]*Article{a}, false)
+	assert.Equal(t, 3, nErrors)
+	assert.Contains(t, report, "error: 'status'")
+
+	a = &Article{}
+	applyArticleMeta(a, []metaLine{{"date", "2019-03-04"}})
+	report, nErrors = formatMetaProblems([]*Article{a}, false)
+	assert.Equal(t, 0, nErrors)
+	assert.Equal(t, "", report)
+	assert.Contains(t, metaProblemsAsStrings(a), "warning: 'tags' is required in blog articles")
+}
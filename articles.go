@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"html/template"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -13,12 +12,6 @@ import (
 	"github.com/kjk/u"
 )
 
-var (
-	notionBlogsStartPage      = "300db9dc27c84958a08b8d0c37f4cfe5"
-	notionWebsiteStartPage    = "568ac4c064c34ef6a6ad0b8d77230681"
-	notionGoCookbookStartPage = "7495260a1daa46118858ad2e049e77e6"
-)
-
 // for Article.Status
 const (
 	statusNormal       = iota // show on main page
@@ -33,11 +26,6 @@ type URLPath struct {
 	Name string
 }
 
-type MetaValue struct {
-	key   string
-	value string
-}
-
 // Article describes a single article
 type Article struct {
 	ID             string
@@ -53,8 +41,17 @@ type Article struct {
 	Status         int
 	Description    string
 	Paths          []URLPath
-	Metadata       []*MetaValue
-	urlOverride    string
+	// headings, for table of contents
+	TOC         []tocHeading
+	urlOverride string
+	// "yes" or "no" to always or never show table of contents, see ShowTOC
+	tocMode string
+	// true if notion page has table of contents block
+	tocInBody bool
+	// problems with metadata, see applyArticleMeta
+	metaProblems []*metaProblem
+	// for articles not from notion, path of the file
+	sourcePath string
 
 	UpdatedAgeStr string
 	Images        []ImageMapping
@@ -66,10 +63,22 @@ type Article struct {
 	page *notionapi.Page
 }
 
-// Articles has info about all articles downloaded from notion
+// ContentSource provides articles e.g. from notion or from a directory
+// with markdown files. Articles from all sources are merged into a single
+// blog, with the same archive pages, feeds and sitemap
+type ContentSource interface {
+	// LoadArticles returns articles with metadata. The body and images
+	// can be set here or in RenderArticles
+	LoadArticles() ([]*Article, error)
+	// RenderArticles sets BodyHTML, HTMLBody and Images of articles
+	// returned by LoadArticles. It's called after articles from all sources
+	// are loaded, so that links to other articles can be resolved
+	RenderArticles(store *Articles) error
+}
+
+// Articles has info about all articles from all content sources
 type Articles struct {
 	idToArticle map[string]*Article
-	idToPage    map[string]*notionapi.Page
 	// all downloaded articles
 	articles []*Article
 	// articles that are not hidden
@@ -156,83 +165,6 @@ func (a *Article) IsHidden() bool {
 	return a.Status == statusHidden || a.Status == statusDeleted || a.Status == statusNotImportant
 }
 
-func parseTags(s string) []string {
-	tags := strings.Split(s, ",")
-	var res []string
-	for _, tag := range tags {
-		tag = strings.TrimSpace(tag)
-		tag = strings.ToLower(tag)
-		// skip the tag I use in quicknotes.io to tag notes for the blog
-		if tag == "for-blog" || tag == "published" || tag == "draft" {
-			continue
-		}
-		res = append(res, tag)
-	}
-	return res
-}
-
-func parseDate(s string) (time.Time, error) {
-	t, err := time.Parse(time.RFC3339, s)
-	if err == nil {
-		return t, nil
-	}
-	t, err = time.Parse("2006-01-02", s)
-	if err == nil {
-		return t, nil
-	}
-	// TODO: more formats?
-	return time.Now(), err
-}
-
-func parseStatus(status string) (int, error) {
-	status = strings.TrimSpace(strings.ToLower(status))
-	if status == "" {
-		return statusNormal, nil
-	}
-	switch status {
-	case "hidden":
-		return statusHidden, nil
-	case "notimportant":
-		return statusNotImportant, nil
-	case "deleted":
-		return statusDeleted, nil
-	default:
-		return 0, fmt.Errorf("'%s' is not a valid status", status)
-	}
-}
-
-func setStatusMust(article *Article, val string) {
-	var err error
-	article.Status, err = parseStatus(val)
-	panicIfErr(err)
-}
-
-func setCollectionMust(article *Article, val string) {
-	collectionURL := ""
-	switch val {
-	case "go-cookbook":
-		collectionURL = "/book/go-cookbook.html"
-		val = "Go Cookbook"
-	case "go-windows":
-		// ignore
-		return
-	}
-	panicIf(collectionURL == "", "'%s' is not a known collection", val)
-	article.Collection = val
-	article.CollectionURL = collectionURL
-
-}
-func setHeaderImageMust(article *Article, val string) {
-	if val[0] != '/' {
-		val = "/" + val
-	}
-	path := filepath.Join("www", val)
-	panicIf(!u.FileExists(path), "File '%s' for @header-image doesn't exist", path)
-	//fmt.Printf("Found HeaderImageURL: %s\n", fileName)
-	uri := netlifyRequestGetFullHost() + val
-	article.HeaderImageURL = uri
-}
-
 func notionPageToArticle(c *notionapi.Client, page *notionapi.Page) *Article {
 	blocks := page.Root.Content
 	//fmt.Printf("extractMetadata: %s-%s, %d blocks\n", title, id, len(blocks))
@@ -246,12 +178,10 @@ func notionPageToArticle(c *notionapi.Client, page *notionapi.Page) *Article {
 		Title: title,
 	}
 	nBlock := 0
-	var err error
-	endLoop := false
 
 	article.PublishedOn = root.CreatedOn()
 	article.UpdatedOn = root.UpdatedOn()
-	var publishedOnOverwrite time.Time
+	var lines []metaLine
 
 	for len(blocks) > 0 {
 		block := blocks[0]
@@ -286,7 +216,8 @@ func notionPageToArticle(c *notionapi.Client, page *notionapi.Page) *Article {
 		}
 		//fmt.Printf("  %d %s '%s'\n", nBlock, block.Type, s)
 
-		// parse generic metadata like "@foo: bar" or "@foo bar"
+		// "@foo: bar" or "@foo bar" lines are not shown but we don't
+		// support any such keys so lint warns about them
 		if s[0] == '@' {
 			s := s[1:]
 			idx := strings.Index(s, ":")
@@ -299,11 +230,7 @@ func notionPageToArticle(c *notionapi.Client, page *notionapi.Page) *Article {
 				key = s[:idx]
 				value = s[idx+1:]
 			}
-			meta := &MetaValue{
-				key:   key,
-				value: value,
-			}
-			article.Metadata = append(article.Metadata, meta)
+			lines = append(lines, metaLine{key: "@" + key, val: strings.TrimSpace(value)})
 			blocks = blocks[1:]
 			nBlock++
 			continue
@@ -314,59 +241,22 @@ func notionPageToArticle(c *notionapi.Client, page *notionapi.Page) *Article {
 			//fmt.Printf("block: %d of type %s: inline.Text is not key/value. s='%s'\n", nBlock, block.Type, s)
 			break
 		}
-		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		key := strings.TrimSpace(parts[0])
 		val := strings.TrimSpace(parts[1])
-		switch key {
-		case "tags":
-			article.Tags = parseTags(val)
-			//fmt.Printf("Tags: %v\n", res.Tags)
-		case "id":
-			articleSetID(article, val)
-			//fmt.Printf("ID: %s\n", res.ID)
-		case "publishedon":
-			// PublishedOn over-writes Date and CreatedAt
-			publishedOnOverwrite, err = parseDate(val)
-			panicIfErr(err)
-			article.inBlog = true
-		case "date", "createdat":
-			article.PublishedOn, err = parseDate(val)
-			panicIfErr(err)
-			article.inBlog = true
-		case "updatedat":
-			article.UpdatedOn, err = parseDate(val)
-			panicIfErr(err)
-		case "status":
-			setStatusMust(article, val)
-		case "description":
-			article.Description = val
-			//fmt.Printf("Description: %s\n", res.Description)
-		case "headerimage":
-			setHeaderImageMust(article, val)
-		case "collection":
-			setCollectionMust(article, val)
-		case "url":
-			article.urlOverride = val
-		default:
+		if f, _ := findMetaField(key); f == nil {
 			// assume that unrecognized meta means this article doesn't have
 			// proper meta tags. It might miss meta-tags that are badly named
-			endLoop = true
-			/*
-				rmCached(page.ID)
-				title := page.Page.Title
-				panicMsg("Unsupported meta '%s' in notion page with id '%s', '%s'", key, normalizeID(page.ID), title)
-			*/
-		}
-		if endLoop {
+			if looksLikeMetaKey(key) && val != "" {
+				article.addMetaProblem(true, "unknown key '%s', metadata ends here and the rest is shown as content", key)
+			}
 			break
 		}
+		lines = append(lines, metaLine{key: key, val: val})
 		blocks = blocks[1:]
 		nBlock++
 	}
 	root.Content = blocks
-
-	if !publishedOnOverwrite.IsZero() {
-		article.PublishedOn = publishedOnOverwrite
-	}
+	applyArticleMeta(article, lines)
 
 	if article.ID == "" {
 		article.ID = id
@@ -385,7 +275,7 @@ func notionPageToArticle(c *notionapi.Client, page *notionapi.Page) *Article {
 	if article.HeaderImageURL == "" && format != nil && format.PageCover != "" {
 		path, err := downloadAndCacheImage(c, format.PageCover)
 		panicIfErr(err)
-		relURL := "/img/" + filepath.Base(path)
+		relURL := imageURL(path)
 		im := ImageMapping{
 			path:        path,
 			relativeURL: relURL,
@@ -401,11 +291,17 @@ func articleSetID(a *Article, v string) {
 	// - blog posts from articles/ directory have integer id
 	// - blog posts imported from quicknotes have id that are strings
 	// - articles written in notion, have notion string id
-	a.ID = strings.TrimSpace(v)
-	id, err := strconv.Atoi(a.ID)
+	a.ID = normalizeArticleID(v)
+}
+
+// normalizeArticleID converts integer ids to the form we use in urls
+func normalizeArticleID(v string) string {
+	v = strings.TrimSpace(v)
+	id, err := strconv.Atoi(v)
 	if err == nil {
-		a.ID = u.EncodeBase64(id)
+		return u.EncodeBase64(id)
 	}
+	return v
 }
 
 func addIDToBlock(block *notionapi.Block, idToBlock map[string]*notionapi.Block) {
@@ -424,6 +320,10 @@ func buildArticleNavigation(article *Article, isRootPage func(string) bool, idTo
 	if len(article.Paths) > 0 {
 		return
 	}
+	// markdown articles are not part of notion hierarchy
+	if article.page == nil {
+		return
+	}
 
 	page := article.page.Root
 	currID := normalizeID(page.ParentID)
@@ -468,47 +368,53 @@ func buildArticlesNavigation(articles *Articles) {
 		addIDToBlock(page.Root, idToBlock)
 	}
 
-	isRoot := func(id string) bool {
-		id = normalizeID(id)
-		switch id {
-		case notionBlogsStartPage, notionWebsiteStartPage, notionGoCookbookStartPage:
-			return true
-		}
-		return false
-	}
-
 	for _, article := range articles.articles {
-		buildArticleNavigation(article, isRoot, idToBlock)
+		buildArticleNavigation(article, site.isNotionRootPage, idToBlock)
 	}
 }
 
-func loadArticles(c *notionapi.Client) *Articles {
-	res := &Articles{}
-	startIDs := []string{notionWebsiteStartPage}
-	res.idToPage = loadAllPages(c, startIDs, useCacheForNotion)
-
-	res.idToArticle = map[string]*Article{}
-	for id, page := range res.idToPage {
-		panicIf(id != normalizeID(id), "bad id '%s' sneaked in", id)
-		article := notionPageToArticle(c, page)
-		if article.urlOverride != "" {
-			fmt.Printf("url override: %s => %s\n", article.urlOverride, article.ID)
+// addArticle makes article findable by its id and, for notion articles,
+// by the id of notion page
+func (a *Articles) addArticle(article *Article) {
+	ids := []string{article.ID}
+	if article.page != nil {
+		ids = append(ids, normalizeID(article.page.ID))
+	}
+	for _, id := range ids {
+		if existing := a.idToArticle[id]; existing != nil && existing != article {
+			panicMsg("articles '%s' and '%s' have the same id '%s'", existing.Title, article.Title, id)
 		}
-		res.idToArticle[id] = article
-		// this might be legacy, short id. If not, we just set the same value twice
-		articleID := article.ID
-		res.idToArticle[articleID] = article
-		if article.IsBlog() {
-			res.blog = append(res.blog, article)
+		a.idToArticle[id] = article
+	}
+	if article.IsBlog() {
+		a.blog = append(a.blog, article)
+	}
+	a.articles = append(a.articles, article)
+}
+
+func loadArticles(sources []ContentSource) *Articles {
+	res := &Articles{
+		idToArticle: map[string]*Article{},
+	}
+	for _, source := range sources {
+		articles, err := source.LoadArticles()
+		panicIfErr(err)
+		for _, article := range articles {
+			if article.urlOverride != "" {
+				fmt.Printf("url override: %s => %s\n", article.urlOverride, article.ID)
+			}
+			res.addArticle(article)
 		}
-		res.articles = append(res.articles, article)
+	}
+	// report all problems at once, not just the first one
+	if report, nErrors := formatMetaProblems(res.articles, false); nErrors > 0 {
+		fmt.Printf("%s\n", report)
+		panicMsg("%d errors in article metadata, use -lint to see all problems", nErrors)
 	}
 
-	for _, article := range res.articles {
-		html, images := notionToHTML(c, article.page, res)
-		article.BodyHTML = string(html)
-		article.HTMLBody = template.HTML(article.BodyHTML)
-		article.Images = append(article.Images, images...)
+	for _, source := range sources {
+		err := source.RenderArticles(res)
+		panicIfErr(err)
 	}
 
 	buildArticlesNavigation(res)
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// For incremental builds we remember, for every file in netlify_static,
+// a hash of inputs it was generated from (article content and metadata,
+// templates, source file for copied files). On the next build we only
+// re-generate files whose inputs changed. Files that didn't change are not
+// touched so they keep their mtimes.
+// If the way we generate files changes, bump buildManifestVersion
+// or use -clean to force a full re-build.
+
+const buildManifestVersion = 1
+
+var (
+	buildManifestPath = "netlify_static_manifest.json"
+
+	prevManifest *buildManifest
+	currManifest *buildManifest
+
+	// hash of all templates, set in loadTemplates
+	templatesHash string
+
+	nNetlifyFilesWritten int
+	nNetlifyFilesSkipped int
+)
+
+type buildManifest struct {
+	Version int `json:"version"`
+	// maps file name relative to netlify_static to a hash of its inputs
+	Files map[string]string `json:"files"`
+}
+
+func newBuildManifest() *buildManifest {
+	return &buildManifest{
+		Version: buildManifestVersion,
+		Files:   map[string]string{},
+	}
+}
+
+// returns nil if manifest doesn't exist or is not valid
+func loadBuildManifest(path string) *buildManifest {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var m buildManifest
+	err = json.Unmarshal(d, &m)
+	if err != nil {
+		fmt.Printf("loadBuildManifest: json.Unmarshal() of '%s' failed with '%s'\n", path, err)
+		return nil
+	}
+	if m.Version != buildManifestVersion || m.Files == nil {
+		return nil
+	}
+	return &m
+}
+
+func saveBuildManifest(path string, m *buildManifest) error {
+	d, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, d, 0644)
+}
+
+func hashBytes(d []byte) string {
+	h := sha1.New()
+	h.Write(d)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// hashStrings returns a hash of all strings
+func hashStrings(a ...string) string {
+	h := sha1.New()
+	for _, s := range a {
+		io.WriteString(h, s)
+		// separator so that "ab", "c" and "a", "bc" have different hashes
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// hash of things shown about an article in lists of articles
+// e.g. on index and archive pages
+func articleMetaHash(a *Article) string {
+	var paths []string
+	for _, p := range a.Paths {
+		paths = append(paths, p.URL, p.Name)
+	}
+	return hashStrings(
+		a.ID,
+		a.URL(),
+		a.Title,
+		strings.Join(a.Tags, ","),
+		fmt.Sprintf("%d %v", a.Status, a.inBlog),
+		a.PublishedOn.String(),
+		a.UpdatedOn.String(),
+		a.Description,
+		a.HeaderImageURL,
+		a.Collection,
+		a.CollectionURL,
+		a.tocMode,
+		strings.Join(paths, "|"),
+	)
+}
+
+// hash of everything that goes into article's page
+func articleHash(a *Article) string {
+	version := ""
+	if a.page != nil {
+		version = fmt.Sprintf("%d", a.page.Root.Version)
+	}
+	return hashStrings(articleMetaHash(a), version, a.BodyHTML)
+}
+
+func articlesMetaHash(articles []*Article) string {
+	var hashes []string
+	for _, a := range articles {
+		hashes = append(hashes, articleMetaHash(a))
+	}
+	return hashStrings(hashes...)
+}
+
+func netlifyOutPath(fileName string) string {
+	fileName = strings.TrimLeft(fileName, "/")
+	return filepath.Join("netlify_static", fileName)
+}
+
+func manifestKey(fileName string) string {
+	return filepath.ToSlash(strings.TrimLeft(fileName, "/"))
+}
+
+// startIncrementalBuild loads manifest of the previous build. If there isn't
+// one (or clean is true), we start from an empty netlify_static directory
+func startIncrementalBuild(clean bool) {
+	prevManifest = nil
+	if !clean {
+		prevManifest = loadBuildManifest(buildManifestPath)
+	}
+	currManifest = newBuildManifest()
+	nNetlifyFilesWritten = 0
+	nNetlifyFilesSkipped = 0
+	if prevManifest != nil {
+		fmt.Printf("Incremental build, %d files in the previous build\n", len(prevManifest.Files))
+		return
+	}
+	fmt.Printf("Full build\n")
+	prevManifest = newBuildManifest()
+	outDir := filepath.Join("netlify_static")
+	err := os.RemoveAll(outDir)
+	panicIfErr(err)
+	err = os.MkdirAll(outDir, 0755)
+	panicIfErr(err)
+}
+
+// finishIncrementalBuild deletes files generated by the previous build
+// that were not generated by this build and saves the manifest
+func finishIncrementalBuild() {
+	var stale []string
+	for name := range prevManifest.Files {
+		if _, ok := currManifest.Files[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+	for _, name := range stale {
+		fmt.Printf("Removing stale %s\n", name)
+		path := netlifyOutPath(name)
+		rmFile(path)
+		removeEmptyDirs(filepath.Dir(path), "netlify_static")
+	}
+	err := saveBuildManifest(buildManifestPath, currManifest)
+	panicIfErr(err)
+	fmt.Printf("Wrote %d files, %d files didn't change, removed %d files\n", nNetlifyFilesWritten, nNetlifyFilesSkipped, len(stale))
+}
+
+// removeEmptyDirs removes dir and its parents, up to (but not including)
+// top, as long as they're empty
+func removeEmptyDirs(dir string, top string) {
+	for dir != top && strings.HasPrefix(dir, top) {
+		// fails if dir is not empty
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// netlifyFileUpToDate records that fileName is generated from inputs
+// with a given hash. Returns true if the file already exists and was
+// generated from the same inputs in the previous build
+func netlifyFileUpToDate(fileName string, inputHash string) bool {
+	key := manifestKey(fileName)
+	currManifest.Files[key] = inputHash
+	if prevManifest.Files[key] != inputHash {
+		return false
+	}
+	_, err := os.Stat(netlifyOutPath(fileName))
+	if err != nil {
+		return false
+	}
+	nNetlifyFilesSkipped++
+	return true
+}
+
+// netlifyCopyFile copies srcPath as fileName in netlify_static, unless
+// it didn't change since the previous build
+func netlifyCopyFile(fileName string, srcPath string) {
+	st, err := os.Stat(srcPath)
+	panicIfErr(err)
+	inputHash := hashStrings(srcPath, fmt.Sprintf("%d", st.Size()), st.ModTime().String())
+	if netlifyFileUpToDate(fileName, inputHash) {
+		return
+	}
+	dstPath := netlifyOutPath(fileName)
+	err = copyFile(dstPath, srcPath)
+	panicIfErr(err)
+	logVerbose("Copied %s => %s\n", srcPath, dstPath)
+	nNetlifyFilesWritten++
+}
+
+// netlifyCopyDir copies files from srcDir to dstDir in netlify_static
+func netlifyCopyDir(dstDir string, srcDir string, shouldSkipFile func(string) bool) int {
+	files, err := getFilesRecur(srcDir, nil)
+	panicIfErr(err)
+	n := 0
+	for _, path := range files {
+		if shouldSkipFile != nil && shouldSkipFile(path) {
+			continue
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		panicIfErr(err)
+		netlifyCopyFile(filepath.ToSlash(filepath.Join(dstDir, rel)), path)
+		n++
+	}
+	return n
+}
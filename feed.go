@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// We generate Atom, RSS 2.0 and JSON Feed 1.1 feeds from the same model.
+// Entry ids only depend on Article.ID so that they don't change when
+// the title (and therefore the url) of an article changes.
+
+const feedMaxEntries = 25
+
+type feedEntry struct {
+	ID      string
+	URL     string
+	Title   string
+	Summary string
+	// html with absolute urls
+	ContentHTML string
+	ImageURL    string
+	Published   time.Time
+	Updated     time.Time
+	Tags        []string
+}
+
+type feedModel struct {
+	Title   string
+	HomeURL string
+	Author  string
+	// the newest Updated of all entries
+	Updated time.Time
+	Entries []*feedEntry
+}
+
+var (
+	reRootRelativeURL = regexp.MustCompile(`(src|href)="/([^/"])`)
+	reSrcSet          = regexp.MustCompile(`srcset="([^"]*)"`)
+)
+
+// makeURLsAbsolute changes src="/img/foo.png" to
+// src="https://blog.kowalczyk.info/img/foo.png", because feed readers
+// show content outside of our website. Urls in srcset are changed too
+func makeURLsAbsolute(html string, host string) string {
+	html = reRootRelativeURL.ReplaceAllString(html, `$1="`+host+`/$2`)
+	return reSrcSet.ReplaceAllStringFunc(html, func(s string) string {
+		// e.g. /img/a-480w.jpg 480w, /img/a.jpg 800w
+		candidates := strings.Split(reSrcSet.FindStringSubmatch(s)[1], ",")
+		for i, c := range candidates {
+			c = strings.TrimSpace(c)
+			if strings.HasPrefix(c, "/") && !strings.HasPrefix(c, "//") {
+				c = host + c
+			}
+			candidates[i] = c
+		}
+		return `srcset="` + strings.Join(candidates, ", ") + `"`
+	})
+}
+
+func feedEntryID(a *Article) string {
+	return site.Host + "/article/" + a.ID
+}
+
+func absoluteURL(uri string) string {
+	if uri == "" || isExternalURL(uri) {
+		return uri
+	}
+	return site.Host + uri
+}
+
+func newFeedEntry(a *Article) *feedEntry {
+	updated := a.UpdatedOn
+	if updated.Before(a.PublishedOn) {
+		updated = a.PublishedOn
+	}
+	return &feedEntry{
+		ID:          feedEntryID(a),
+		URL:         site.Host + a.URL(),
+		Title:       a.Title,
+		Summary:     a.Description,
+		ContentHTML: makeURLsAbsolute(a.BodyHTML, site.Host),
+		ImageURL:    absoluteURL(a.HeaderImageURL),
+		Published:   a.PublishedOn,
+		Updated:     updated,
+		Tags:        a.Tags,
+	}
+}
+
+// newFeedModel creates a feed with the most recently published articles
+func newFeedModel(title string, articles []*Article) *feedModel {
+	articles = copyAndSortArticles(articles)
+	n := feedMaxEntries
+	if n > len(articles) {
+		n = len(articles)
+	}
+	res := &feedModel{
+		Title:   title,
+		HomeURL: site.Host + "/",
+		Author:  site.FeedAuthor,
+	}
+	// copyAndSortArticles sorts from oldest
+	size := len(articles)
+	for i := 0; i < n; i++ {
+		e := newFeedEntry(articles[size-1-i])
+		if e.Updated.After(res.Updated) {
+			res.Updated = e.Updated
+		}
+		res.Entries = append(res.Entries, e)
+	}
+	return res
+}
+
+// blog articles for main feeds
+func feedArticles(store *Articles, excludeNotes bool) []*Article {
+	articles := store.getBlogNotHidden()
+	if excludeNotes {
+		articles = filterArticlesByTag(articles, "note", false)
+	}
+	return articles
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Title      string          `xml:"title"`
+	Links      []atomLink      `xml:"link"`
+	ID         string          `xml:"id"`
+	Published  string          `xml:"published"`
+	Updated    string          `xml:"updated"`
+	Categories []*atomCategory `xml:"category"`
+	Summary    *atomText       `xml:"summary,omitempty"`
+	Content    *atomText       `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string       `xml:"title"`
+	Links   []atomLink   `xml:"link"`
+	ID      string       `xml:"id"`
+	Updated string       `xml:"updated"`
+	Author  *atomPerson  `xml:"author,omitempty"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+func feedTimeRFC3339(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	d, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.Write(d)
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// genAtomFeed generates Atom feed. feedURL is the url of the feed itself
+func genAtomFeed(f *feedModel, feedURL string) ([]byte, error) {
+	feed := &atomFeed{
+		Title: f.Title,
+		Links: []atomLink{
+			{Href: feedURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.HomeURL, Rel: "alternate", Type: "text/html"},
+		},
+		ID:      feedURL,
+		Updated: feedTimeRFC3339(f.Updated),
+	}
+	if f.Author != "" {
+		feed.Author = &atomPerson{Name: f.Author}
+	}
+	for _, e := range f.Entries {
+		ae := &atomEntry{
+			Title: e.Title,
+			Links: []atomLink{
+				{Href: e.URL, Rel: "alternate", Type: "text/html"},
+			},
+			ID:        e.ID,
+			Published: feedTimeRFC3339(e.Published),
+			Updated:   feedTimeRFC3339(e.Updated),
+			Content:   &atomText{Type: "html", Body: e.ContentHTML},
+		}
+		for _, tag := range e.Tags {
+			ae.Categories = append(ae.Categories, &atomCategory{Term: tag})
+		}
+		if e.Summary != "" {
+			ae.Summary = &atomText{Type: "text", Body: e.Summary}
+		}
+		feed.Entries = append(feed.Entries, ae)
+	}
+	return marshalXML(feed)
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	AtomLink      atomLink   `xml:"http://www.w3.org/2005/Atom link"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name    `xml:"rss"`
+	Version string      `xml:"version,attr"`
+	Channel *rssChannel `xml:"channel"`
+}
+
+// genRSSFeed generates RSS 2.0 feed. RSS has no separate summary so
+// description is the content
+func genRSSFeed(f *feedModel, feedURL string) ([]byte, error) {
+	channel := &rssChannel{
+		Title:         f.Title,
+		Link:          f.HomeURL,
+		Description:   f.Title,
+		AtomLink:      atomLink{Href: feedURL, Rel: "self", Type: "application/rss+xml"},
+		LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+	}
+	for _, e := range f.Entries {
+		item := &rssItem{
+			Title:       e.Title,
+			Link:        e.URL,
+			GUID:        rssGUID{IsPermaLink: "false", ID: e.ID},
+			PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+			Categories:  e.Tags,
+			Description: e.ContentHTML,
+		}
+		channel.Items = append(channel.Items, item)
+	}
+	feed := &rssFeed{
+		Version: "2.0",
+		Channel: channel,
+	}
+	return marshalXML(feed)
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string   `json:"id"`
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentHTML   string   `json:"content_html"`
+	Summary       string   `json:"summary,omitempty"`
+	Image         string   `json:"image,omitempty"`
+	DatePublished string   `json:"date_published"`
+	DateModified  string   `json:"date_modified"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+type jsonFeed struct {
+	Version     string            `json:"version"`
+	Title       string            `json:"title"`
+	HomePageURL string            `json:"home_page_url"`
+	FeedURL     string            `json:"feed_url"`
+	Authors     []*jsonFeedAuthor `json:"authors,omitempty"`
+	Items       []*jsonFeedItem   `json:"items"`
+}
+
+// genJSONFeed generates JSON Feed 1.1 (https://jsonfeed.org/version/1.1)
+func genJSONFeed(f *feedModel, feedURL string) ([]byte, error) {
+	feed := &jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.HomeURL,
+		FeedURL:     feedURL,
+		Items:       []*jsonFeedItem{},
+	}
+	if f.Author != "" {
+		feed.Authors = []*jsonFeedAuthor{{Name: f.Author}}
+	}
+	for _, e := range f.Entries {
+		item := &jsonFeedItem{
+			ID:            e.ID,
+			URL:           e.URL,
+			Title:         e.Title,
+			ContentHTML:   e.ContentHTML,
+			Summary:       e.Summary,
+			Image:         e.ImageURL,
+			DatePublished: feedTimeRFC3339(e.Published),
+			DateModified:  feedTimeRFC3339(e.Updated),
+			Tags:          e.Tags,
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// don't turn < into < in content_html
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(feed)
+	return buf.Bytes(), err
+}
+
+// feedFormat describes how to generate a feed in a given format
+type feedFormat struct {
+	// file name (without extension) of the main feed
+	name string
+	// extension of the file e.g. ".xml"
+	ext      string
+	mimeType string
+	title    string
+	gen      func(f *feedModel, feedURL string) ([]byte, error)
+}
+
+var feedFormats = []*feedFormat{
+	{name: "atom", ext: ".xml", mimeType: "application/atom+xml", title: "Atom", gen: genAtomFeed},
+	{name: "rss", ext: ".xml", mimeType: "application/rss+xml", title: "RSS 2.0", gen: genRSSFeed},
+	{name: "feed", ext: ".json", mimeType: "application/feed+json", title: "JSON Feed", gen: genJSONFeed},
+}
+
+// FeedLink is a link to a feed, for <link rel="alternate">
+type FeedLink struct {
+	Type  string
+	Title string
+	URL   string
+	// e.g. "Atom"
+	Format string
+}
+
+func (ff *feedFormat) fileName(dir string, suffix string) string {
+	return strings.TrimSuffix(dir, "/") + "/" + ff.name + suffix + ff.ext
+}
+
+// feedLinks returns links to feeds in all formats in dir e.g. for dir
+// "/tag/go/": /tag/go/atom.xml, /tag/go/rss.xml, /tag/go/feed.json
+func feedLinks(dir string, suffix string, title string) []*FeedLink {
+	var res []*FeedLink
+	for _, ff := range feedFormats {
+		fl := &FeedLink{
+			Type:   ff.mimeType,
+			Title:  ff.title,
+			URL:    ff.fileName(dir, suffix),
+			Format: ff.title,
+		}
+		if title != "" {
+			fl.Title = title + " (" + ff.title + ")"
+		}
+		res = append(res, fl)
+	}
+	return res
+}
+
+// netlifyWriteFeeds writes feeds in all formats for a given model e.g.
+// for suffix "-all" it writes /atom-all.xml, /rss-all.xml and /feed-all.json.
+// The feeds are served from urlDir, which is dir unless we add a rewrite
+func netlifyWriteFeeds(f *feedModel, dir string, urlDir string, suffix string) {
+	for _, ff := range feedFormats {
+		fileName := ff.fileName(dir, suffix)
+		uri := ff.fileName(urlDir, suffix)
+		d, err := ff.gen(f, site.Host+uri)
+		panicIfErr(err)
+		netlifyWriteFile(fileName, d)
+		if uri != fileName {
+			// rules match unescaped paths
+			from, err := url.PathUnescape(uri)
+			panicIfErr(err)
+			netlifyAddRewrite(from, fileName)
+		}
+	}
+}
+
+// tag names can have characters that are not valid in file names, so
+// per-tag feeds are in /feeds/tag/${urlified tag}/ and we rewrite
+// /tag/${tag}/atom.xml etc. to them
+func tagFeedsDir(tag string) string {
+	name := tagURLName(tag)
+	// Netlify doesn't deploy dot files e.g. for .net tag
+	if strings.HasPrefix(name, ".") {
+		name = "dot" + name[1:]
+	}
+	return "/feeds/tag/" + name
+}
+
+// tagFeedsURLDir returns url of the directory with feeds for a tag. The tag
+// is escaped because it can have spaces or # e.g. "objective c", "c#"
+func tagFeedsURLDir(tag string) string {
+	return "/tag/" + url.PathEscape(tag)
+}
+
+func collectionFeedsDir(collection string) string {
+	return "/feeds/collection/" + urlify(collection)
+}
+
+func collectionFeedsURLDir(collection string) string {
+	return "/collection/" + urlify(collection)
+}
+
+// netlifyWriteTagFeeds writes feeds with blog articles with a given tag
+func netlifyWriteTagFeeds(store *Articles, tag string) {
+	articles := filterArticlesByTag(store.getBlogNotHidden(), tag, true)
+	f := newFeedModel(site.FeedTitle+": "+tag, articles)
+	netlifyWriteFeeds(f, tagFeedsDir(tag), tagFeedsURLDir(tag), "")
+}
+
+func articlesInCollection(articles []*Article, collection string) []*Article {
+	var res []*Article
+	for _, a := range articles {
+		if a.Collection == collection {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// netlifyWriteCollectionFeeds writes feeds for each collection, with all
+// its articles (not only blog articles)
+func netlifyWriteCollectionFeeds(store *Articles) {
+	collections := map[string]bool{}
+	for _, a := range store.getNotHidden() {
+		if a.Collection != "" {
+			collections[a.Collection] = true
+		}
+	}
+	var names []string
+	for name := range collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		articles := articlesInCollection(store.getNotHidden(), name)
+		f := newFeedModel(site.FeedTitle+": "+name, articles)
+		netlifyWriteFeeds(f, collectionFeedsDir(name), collectionFeedsURLDir(name), "")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setTestSiteConfig() {
+	setSiteConfig(&siteConfig{
+		Host:            "https://example.com",
+		FeedTitle:       "Example blog",
+		FeedAuthor:      "Joe",
+		NotionStartPage: "568ac4c064c34ef6a6ad0b8d77230681",
+	})
+}
+
+func TestMakeURLsAbsolute(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp string
+	}{
+		{`<img src="/img/a.png">`, `<img src="https://example.com/img/a.png">`},
+		{`<a href="/article/x.html">`, `<a href="https://example.com/article/x.html">`},
+		{`<a href="//cdn.com/x.js">`, `<a href="//cdn.com/x.js">`},
+		{`<a href="https://foo.com/">`, `<a href="https://foo.com/">`},
+		{`<a href="x.html">`, `<a href="x.html">`},
+		{
+			`<img src="/img/a.jpg" srcset="/img/a-480w.jpg 480w,/img/a.jpg 800w, //cdn.com/b.jpg 2x">`,
+			`<img src="https://example.com/img/a.jpg" srcset="https://example.com/img/a-480w.jpg 480w, https://example.com/img/a.jpg 800w, //cdn.com/b.jpg 2x">`,
+		},
+	}
+	for _, test := range tests {
+		got := makeURLsAbsolute(test.s, "https://example.com")
+		assert.Equal(t, test.exp, got)
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	setTestSiteConfig()
+	mkArticle := func(id string, published string) *Article {
+		d, err := time.Parse("2006-01-02", published)
+		assert.NoError(t, err)
+		return &Article{
+			ID:          id,
+			Title:       "Title " + id,
+			Description: "about " + id,
+			Tags:        []string{"go"},
+			PublishedOn: d,
+			UpdatedOn:   d.Add(time.Hour),
+			BodyHTML:    `<p><img src="/img/` + id + `.png" srcset="/img/` + id + `-480w.png 480w, /img/` + id + `.png 800w"></p>`,
+		}
+	}
+	articles := []*Article{
+		mkArticle("old", "2018-01-01"),
+		mkArticle("new", "2019-01-01"),
+	}
+	f := newFeedModel(site.FeedTitle, articles)
+	assert.Equal(t, 2, len(f.Entries))
+	e := f.Entries[0]
+	assert.Equal(t, "https://example.com/article/new", e.ID)
+	assert.Equal(t, "https://example.com/article/new/title-new.html", e.URL)
+	assert.Equal(t, `<p><img src="https://example.com/img/new.png" srcset="https://example.com/img/new-480w.png 480w, https://example.com/img/new.png 800w"></p>`, e.ContentHTML)
+	assert.Equal(t, "2019-01-01T01:00:00Z", feedTimeRFC3339(f.Updated))
+
+	d, err := genAtomFeed(f, "https://example.com/atom.xml")
+	assert.NoError(t, err)
+	s := string(d)
+	assert.True(t, strings.Contains(s, `<id>https://example.com/article/new</id>`))
+	assert.True(t, strings.Contains(s, `<category term="go"></category>`))
+	assert.True(t, strings.Contains(s, `<summary type="text">about new</summary>`))
+	assert.True(t, strings.Contains(s, `<updated>2019-01-01T01:00:00Z</updated>`))
+
+	d, err = genRSSFeed(f, "https://example.com/rss.xml")
+	assert.NoError(t, err)
+	s = string(d)
+	assert.True(t, strings.Contains(s, `<guid isPermaLink="false">https://example.com/article/old</guid>`))
+	assert.True(t, strings.Contains(s, `<pubDate>Mon, 01 Jan 2018 00:00:00 +0000</pubDate>`))
+
+	d, err = genJSONFeed(f, "https://example.com/feed.json")
+	assert.NoError(t, err)
+	var jf jsonFeed
+	err = json.Unmarshal(d, &jf)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://jsonfeed.org/version/1.1", jf.Version)
+	assert.Equal(t, "Joe", jf.Authors[0].Name)
+	assert.Equal(t, 2, len(jf.Items))
+	assert.Equal(t, "about old", jf.Items[1].Summary)
+	assert.Equal(t, []string{"go"}, jf.Items[1].Tags)
+}
+
+func TestFeedLinks(t *testing.T) {
+	links := feedLinks(tagFeedsURLDir("go"), "", "Articles tagged go")
+	assert.Equal(t, 3, len(links))
+	assert.Equal(t, "/tag/go/atom.xml", links[0].URL)
+	assert.Equal(t, "Articles tagged go (Atom)", links[0].Title)
+	assert.Equal(t, "/tag/go/feed.json", links[2].URL)
+	assert.Equal(t, "application/feed+json", links[2].Type)
+
+	links = feedLinks(tagFeedsURLDir("objective c"), "", "")
+	assert.Equal(t, "/tag/objective%20c/atom.xml", links[0].URL)
+	assert.Equal(t, "/tag/c%23", tagFeedsURLDir("c#"))
+	assert.Equal(t, "/tag/c++", tagFeedsURLDir("c++"))
+
+	links = feedLinks("/", "-all", "")
+	assert.Equal(t, "/rss-all.xml", links[1].URL)
+	assert.Equal(t, "RSS 2.0", links[1].Title)
+
+	assert.Equal(t, "/feeds/tag/csharp", tagFeedsDir("c#"))
+	assert.Equal(t, "/feeds/tag/dotnet", tagFeedsDir(".net"))
+	assert.Equal(t, "/collection/go-cookbook", collectionFeedsURLDir("Go Cookbook"))
+}
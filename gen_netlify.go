@@ -19,7 +19,6 @@ import (
 	uuid "github.com/satori/go.uuid"
 	"github.com/segmentio/ksuid"
 	"github.com/sony/sonyflake"
-	atom "github.com/thomas11/atomgenerator"
 )
 
 func copyAndSortArticles(articles []*Article) []*Article {
@@ -32,48 +31,6 @@ func copyAndSortArticles(articles []*Article) []*Article {
 	return res
 }
 
-func genAtomXML(store *Articles, excludeNotes bool) ([]byte, error) {
-	articles := store.getBlogNotHidden()
-	if excludeNotes {
-		articles = filterArticlesByTag(articles, "note", false)
-	}
-	articles = copyAndSortArticles(articles)
-	n := 25
-	if n > len(articles) {
-		n = len(articles)
-	}
-
-	latest := make([]*Article, n, n)
-	size := len(articles)
-	for i := 0; i < n; i++ {
-		latest[i] = articles[size-1-i]
-	}
-
-	pubTime := time.Now()
-	if len(articles) > 0 {
-		pubTime = articles[0].PublishedOn
-	}
-
-	feed := &atom.Feed{
-		Title:   "Krzysztof Kowalczyk blog",
-		Link:    "https://blog.kowalczyk.info/atom.xml",
-		PubDate: pubTime,
-	}
-
-	for _, a := range latest {
-		//id := fmt.Sprintf("tag:blog.kowalczyk.info,1999:%d", a.Id)
-		e := &atom.Entry{
-			Title:   a.Title,
-			Link:    "https://blog.kowalczyk.info" + a.URL(),
-			Content: a.BodyHTML,
-			PubDate: a.PublishedOn,
-		}
-		feed.AddEntry(e)
-	}
-
-	return feed.GenXml()
-}
-
 func netlifyPath(fileName string) string {
 	fileName = strings.TrimLeft(fileName, "/")
 	path := filepath.Join("netlify_static", fileName)
@@ -82,14 +39,25 @@ func netlifyPath(fileName string) string {
 	return path
 }
 
+// netlifyWriteFile writes d as fileName in netlify_static, unless it
+// has the same content as in the previous build
 func netlifyWriteFile(fileName string, d []byte) {
+	if netlifyFileUpToDate(fileName, hashBytes(d)) {
+		return
+	}
+	netlifyWriteFileRaw(fileName, d)
+}
+
+func netlifyWriteFileRaw(fileName string, d []byte) {
 	path := netlifyPath(fileName)
 	//fmt.Printf("%s\n", path)
-	ioutil.WriteFile(path, d, 0644)
+	err := ioutil.WriteFile(path, d, 0644)
+	panicIfErr(err)
+	nNetlifyFilesWritten++
 }
 
 func netlifyRequestGetFullHost() string {
-	return "https://blog.kowalczyk.info"
+	return site.Host
 }
 
 // https://www.linkedin.com/shareArticle?mini=true&;url=https://nodesource.com/blog/why-the-new-v8-is-so-damn-fast"
@@ -117,7 +85,11 @@ func makeTwitterShareURL(article *Article) string {
 	title := url.QueryEscape(article.Title)
 	uri := netlifyRequestGetFullHost() + article.URL()
 	uri = url.QueryEscape(uri)
-	return fmt.Sprintf(`https://twitter.com/intent/tweet?text=%s&url=%s&via=kjk`, title, uri)
+	via := ""
+	if site.TwitterHandle != "" {
+		via = "&via=" + url.QueryEscape(strings.TrimPrefix(site.TwitterHandle, "@"))
+	}
+	return fmt.Sprintf(`https://twitter.com/intent/tweet?text=%s&url=%s%s`, title, uri, via)
 }
 
 // TagInfo represents a single tag for articles
@@ -168,23 +140,37 @@ func buildTags(articles []*Article) []*TagInfo {
 	return res
 }
 
+// tagURLName returns tag in a form that can be used in file names
+func tagURLName(tag string) string {
+	// must manually resolve conflict due to urlify
+	tagInPath := tag
+	if tag == "c#" {
+		tagInPath = "csharp"
+	} else if tag == "c++" {
+		tagInPath = "cplusplus"
+	}
+	return urlify(tagInPath)
+}
+
 func netlifyWriteArticlesArchiveForTag(store *Articles, tag string) {
 	path := "/archives.html"
 	articles := store.getBlogNotHidden()
+	feeds := feedLinks("/", "", "")
 	if tag != "" {
 		articles = filterArticlesByTag(articles, tag, true)
-		// must manually resolve conflict due to urlify
-		tagInPath := tag
-		if tag == "c#" {
-			tagInPath = "csharp"
-		} else if tag == "c++" {
-			tagInPath = "cplusplus"
-		}
-		tagInPath = urlify(tagInPath)
-		path = fmt.Sprintf("/article/archives-by-tag-%s.html", tagInPath)
+		path = fmt.Sprintf("/article/archives-by-tag-%s.html", tagURLName(tag))
 		from := "/tag/" + tag
 		netlifyAddRewrite(from, path)
+		netlifyWriteTagFeeds(store, tag)
+		feeds = feedLinks(tagFeedsURLDir(tag), "", "Articles tagged "+tag)
+	}
+
+	tags := buildTags(articles)
+	var tagNames []string
+	for _, ti := range tags {
+		tagNames = append(tagNames, fmt.Sprintf("%s %d", ti.Name, ti.Count))
 	}
+	inputHash := hashStrings(tag, articlesMetaHash(articles), strings.Join(tagNames, ","))
 
 	model := struct {
 		AnalyticsCode string
@@ -193,15 +179,17 @@ func netlifyWriteArticlesArchiveForTag(store *Articles, tag string) {
 		Tag           string
 		Years         []Year
 		Tags          []*TagInfo
+		Feeds         []*FeedLink
 	}{
-		AnalyticsCode: analyticsCode,
+		AnalyticsCode: site.AnalyticsCode,
 		PostsCount:    len(articles),
 		Years:         buildYearsFromArticles(articles),
 		Tag:           tag,
-		Tags:          buildTags(articles),
+		Tags:          tags,
+		Feeds:         feeds,
 	}
 
-	netlifyExecTemplate(path, tmplArchive, model)
+	netlifyExecTemplateIfChanged(path, tmplArchive, inputHash, model)
 }
 
 func skipTmplFiles(path string) bool {
@@ -211,40 +199,52 @@ func skipTmplFiles(path string) bool {
 	return false
 }
 
-func copyImages() {
+// copyImages copies all images in notion cache or, with -only-used-images,
+// only images used by articles. They're named by their content, see imageURL
+func copyImages(store *Articles) {
 	srcDir := filepath.Join("notion_cache", "img")
-	dstDir := filepath.Join("netlify_static", "img")
-	dirCopyRecur(dstDir, srcDir, nil)
+	if !flgOnlyUsedImages {
+		files, err := getFilesRecur(srcDir, nil)
+		panicIfErr(err)
+		for _, path := range files {
+			netlifyCopyFile(imageURL(path), path)
+		}
+	}
+
+	// images of articles from other content sources are not in notion cache
+	for _, article := range store.articles {
+		for _, im := range article.Images {
+			if !flgOnlyUsedImages && filepath.Dir(im.path) == srcDir {
+				continue
+			}
+			netlifyCopyFile(im.relativeURL, im.path)
+		}
+	}
 }
 
 func netlifyBuild(store *Articles) {
 	// verify we're in the right directory
 	_, err := os.Stat("netlify_static")
 	panicIfErr(err)
-	outDir := filepath.Join("netlify_static")
-	err = os.RemoveAll(outDir)
-	panicIfErr(err)
-	err = os.MkdirAll(outDir, 0755)
-	panicIfErr(err)
-	nCopied, err := dirCopyRecur(outDir, "www", skipTmplFiles)
-	panicIfErr(err)
+	startIncrementalBuild(flgCleanBuild)
+	// in -watch mode we build more than once
+	netlifyRedirects = nil
+	allTags = nil
+	nCopied := netlifyCopyDir("", "www", skipTmplFiles)
 	fmt.Printf("Copied %d files\n", nCopied)
 
 	netlifyAddStaticRedirects()
 	netlifyAddRewrite("/favicon.ico", "/static/favicon.ico")
 	//netlifyAddRewrite("/book/", "/static/documents.html")
 	//netflifyAddTempRedirect("/book/*", "/article/:splat")
-	netflifyAddTempRedirect("/software/sumatrapdf*", "https://www.sumatrapdfreader.org/:splat")
-
-	netflifyAddTempRedirect("/articles/", "/documents.html")
-	netflifyAddTempRedirect("/articles/index.html", "/documents.html")
-	netflifyAddTempRedirect("/static/documents.html", "/documents.html")
-	netflifyAddTempRedirect("/software/index.html", "/software/")
 
 	{
 		// url: /book/go-cookbook.html
 		model := struct {
-		}{}
+			AnalyticsCode string
+		}{
+			AnalyticsCode: site.AnalyticsCode,
+		}
 		netlifyExecTemplate("/book/go-cookbook.html", tmplGoCookBook, model)
 		netlifyAddRewrite("/articles/go-cookbook.html", "/book/go-cookbook.html")
 	}
@@ -252,7 +252,10 @@ func netlifyBuild(store *Articles) {
 	{
 		// url: /book/windows-programming-in-go.html
 		model := struct {
-		}{}
+			AnalyticsCode string
+		}{
+			AnalyticsCode: site.AnalyticsCode,
+		}
 		netlifyExecTemplate("/book/go-cookbook.html", tmplGoCookBook, model)
 		netlifyAddRewrite("/articles/go-cookbook.html", "/book/go-cookbook.html")
 	}
@@ -264,7 +267,8 @@ func netlifyBuild(store *Articles) {
 			articles = articles[:5]
 		}
 		articleCount := len(articles)
-		websiteIndexPage := store.idToArticle[notionWebsiteStartPage]
+		websiteIndexPage := store.idToArticle[site.NotionStartPage]
+		inputHash := hashStrings(articlesMetaHash(articles), articleHash(websiteIndexPage))
 		model := struct {
 			AnalyticsCode string
 			Article       *Article
@@ -272,13 +276,13 @@ func netlifyBuild(store *Articles) {
 			ArticleCount  int
 			WebsiteHTML   template.HTML
 		}{
-			AnalyticsCode: analyticsCode,
+			AnalyticsCode: site.AnalyticsCode,
 			Article:       nil, // always nil
 			ArticleCount:  articleCount,
 			Articles:      articles,
 			WebsiteHTML:   websiteIndexPage.HTMLBody,
 		}
-		netlifyExecTemplate("/index.html", tmplMainPage, model)
+		netlifyExecTemplateIfChanged("/index.html", tmplMainPage, inputHash, model)
 	}
 
 	// TODO: maybe just use /archive.html
@@ -292,12 +296,13 @@ func netlifyBuild(store *Articles) {
 			Articles      []*Article
 			ArticleCount  int
 		}{
-			AnalyticsCode: analyticsCode,
+			AnalyticsCode: site.AnalyticsCode,
 			Article:       nil, // always nil
 			ArticleCount:  articleCount,
 			Articles:      articles,
 		}
-		netlifyExecTemplate("/blogindex.html", tmplBlogIndex, model)
+		inputHash := articlesMetaHash(articles)
+		netlifyExecTemplateIfChanged("/blogindex.html", tmplBlogIndex, inputHash, model)
 	}
 
 	{
@@ -325,32 +330,32 @@ func netlifyBuild(store *Articles) {
 			Article       *Article
 			Articles      []*Article
 		}{
-			AnalyticsCode: analyticsCode,
+			AnalyticsCode: site.AnalyticsCode,
 			Article:       nil, // always nil
 			Articles:      articles,
 		}
 		netlifyExecTemplate("/changelog.html", tmplChangelog, model)
 	}
 
-	copyImages()
+	copyImages(store)
 
 	{
-		// /atom.xml
-		d, err := genAtomXML(store, true)
-		panicIfErr(err)
-		netlifyWriteFile("/atom.xml", d)
+		// /atom.xml, /rss.xml, /feed.json
+		f := newFeedModel(site.FeedTitle, feedArticles(store, true))
+		netlifyWriteFeeds(f, "/", "/", "")
 	}
 
 	{
-		// /atom-all.xml
-		d, err := genAtomXML(store, false)
-		panicIfErr(err)
-		netlifyWriteFile("/atom-all.xml", d)
+		// /atom-all.xml, /rss-all.xml, /feed-all.json
+		f := newFeedModel(site.FeedTitle, feedArticles(store, false))
+		netlifyWriteFeeds(f, "/", "/", "-all")
 	}
 
+	netlifyWriteCollectionFeeds(store)
+
 	{
 		// /blog/ and /kb/ are only for redirects, we only handle /article/ at this point
-		logVerbose("%d articles\n", len(store.idToPage))
+		logVerbose("%d articles\n", len(store.articles))
 		for _, article := range store.articles {
 			canonicalURL := netlifyRequestGetFullHost() + article.URL()
 			model := struct {
@@ -367,8 +372,9 @@ func netlifyBuild(store *Articles) {
 				FacebookShareURL   string
 				LinkedInShareURL   string
 				GooglePlusShareURL string
+				CollectionFeeds    []*FeedLink
 			}{
-				AnalyticsCode:      analyticsCode,
+				AnalyticsCode:      site.AnalyticsCode,
 				Article:            article,
 				CanonicalURL:       canonicalURL,
 				CoverImage:         article.HeaderImageURL,
@@ -379,13 +385,16 @@ func netlifyBuild(store *Articles) {
 				LinkedInShareURL:   makeLinkedinShareURL(article),
 				GooglePlusShareURL: makeGooglePlusShareURL(article),
 			}
+			if article.Collection != "" {
+				model.CollectionFeeds = feedLinks(collectionFeedsURLDir(article.Collection), "", article.Collection)
+			}
 			if article.page != nil {
 				id := normalizeID(article.page.ID)
 				model.NotionEditURL = "https://notion.so/" + id
 			}
 			path := fmt.Sprintf("/article/%s.html", article.ID)
 			logVerbose("%s => %s, %s, %s\n", article.ID, path, article.URL(), article.Title)
-			netlifyExecTemplate(path, tmplArticle, model)
+			netlifyExecTemplateIfChanged(path, tmplArticle, articleHash(article), model)
 			if article.urlOverride != "" {
 				//fmt.Printf("url override: %s => %s\n", article.urlOverride, path)
 				netlifyAddRewrite(article.urlOverride, path)
@@ -402,14 +411,40 @@ func netlifyBuild(store *Articles) {
 				tags[tag] = struct{}{}
 			}
 		}
+		// sorted so that _redirects doesn't change between builds
+		var sortedTags []string
 		for tag := range tags {
+			sortedTags = append(sortedTags, tag)
+		}
+		sort.Strings(sortedTags)
+		for _, tag := range sortedTags {
 			netlifyWriteArticlesArchiveForTag(store, tag)
 		}
 	}
 
+	{
+		// /search.html and its index in /search/
+		netlifyWriteSearchIndex(store.articles)
+		model := struct {
+			AnalyticsCode string
+			Article       *Article
+		}{
+			AnalyticsCode: site.AnalyticsCode,
+		}
+		netlifyExecTemplate("/search.html", tmplSearch, model)
+	}
+
+	{
+		// /404.html, also served for redirects to deleted articles
+		model := struct {
+			URL string
+		}{}
+		netlifyExecTemplate(goneURL, tmpl404, model)
+	}
+
 	{
 		// /sitemap.xml
-		data, err := genSiteMap(store, "https://blog.kowalczyk.info")
+		data, err := genSiteMap(store, site.Host)
 		panicIfErr(err)
 		netlifyWriteFile("/sitemap.xml", data)
 	}
@@ -425,11 +460,16 @@ func netlifyBuild(store *Articles) {
 		betterGUID := betterguid.New()
 		uuid := uuid.NewV4()
 
+		// sonyflake needs a private ip address, which we might not have
+		// e.g. when building in a sandbox without network
+		sfidstr := "sonyflake not available"
 		flake := sonyflake.NewSonyflake(sonyflake.Settings{})
-		sfid, err := flake.NextID()
-		sfidstr := fmt.Sprintf("%x", sfid)
-		if err != nil {
-			sfidstr = err.Error()
+		if flake != nil {
+			sfid, err := flake.NextID()
+			sfidstr = fmt.Sprintf("%x", sfid)
+			if err != nil {
+				sfidstr = err.Error()
+			}
 		}
 
 		model := struct {
@@ -449,7 +489,7 @@ func netlifyBuild(store *Articles) {
 			Sonyflake:     sfidstr,
 			Sid:           sid.Id(),
 			UUIDv4:        uuid.String(),
-			AnalyticsCode: analyticsCode,
+			AnalyticsCode: site.AnalyticsCode,
 		}
 
 		// make sure /tools/generate-unique-id is served as html
@@ -464,6 +504,8 @@ func netlifyBuild(store *Articles) {
 	// no longer care about /worklog
 
 	netlifyAddArticleRedirects(store)
-	netlifyWriteRedirects()
-	writeCaddyConfig()
+	writeRedirects()
+	netlifyWriteHeaders()
+
+	finishIncrementalBuild()
 }
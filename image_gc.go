@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kjk/notionapi"
+)
+
+// Images of notion pages are cached in notion_cache/img, named by sha1 of
+// their url, and their resized variants in notion_cache/img_variants, named
+// by sha1 of the image content.
+// Images no longer used by any article stay there forever so -gc-images
+// reports (and with -gc-images-delete deletes) them. It also checks that
+// cached images decode, to catch truncated or failed downloads.
+
+type imageCacheReport struct {
+	nFiles     int
+	unused     []string
+	unusedSize int64
+	// path => why it's bad
+	corrupt map[string]string
+}
+
+// usedImagePaths returns paths of all images used by articles
+func usedImagePaths(store *Articles) map[string]bool {
+	res := map[string]bool{}
+	for _, article := range store.articles {
+		for _, im := range article.Images {
+			res[filepath.Clean(im.path)] = true
+		}
+	}
+	return res
+}
+
+// verifyImage returns an error if image at path can't be decoded.
+// Other files, like pdfs, are only checked for not being empty
+func verifyImage(path string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.Size() == 0 {
+		return fmt.Errorf("empty file")
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp":
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, _, err = image.Decode(f)
+		return err
+	case ".svg":
+		_, err := readImageInfo(path)
+		return err
+	}
+	return nil
+}
+
+// checkImageCache finds images in dirs that are not used and images
+// that don't decode
+func checkImageCache(dirs []string, used map[string]bool) *imageCacheReport {
+	res := &imageCacheReport{
+		corrupt: map[string]string{},
+	}
+	for _, dir := range dirs {
+		files, err := getFilesRecur(dir, nil)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Printf("checkImageCache: failed to list files in '%s' with '%s'\n", dir, err)
+			}
+			continue
+		}
+		for _, path := range files {
+			path = filepath.Clean(path)
+			res.nFiles++
+			if err := verifyImage(path); err != nil {
+				res.corrupt[path] = err.Error()
+			}
+			if !used[path] {
+				res.unused = append(res.unused, path)
+				if st, err := os.Stat(path); err == nil {
+					res.unusedSize += st.Size()
+				}
+			}
+		}
+	}
+	sort.Strings(res.unused)
+	return res
+}
+
+func (r *imageCacheReport) String() string {
+	var lines []string
+	for _, path := range r.unused {
+		lines = append(lines, "unused: "+path)
+	}
+	var corrupt []string
+	for path := range r.corrupt {
+		corrupt = append(corrupt, path)
+	}
+	sort.Strings(corrupt)
+	for _, path := range corrupt {
+		lines = append(lines, fmt.Sprintf("corrupt: %s (%s)", path, r.corrupt[path]))
+	}
+	s := fmt.Sprintf("Checked %d images: %d unused (%s), %d corrupt", r.nFiles, len(r.unused), formatSize(r.unusedSize), len(r.corrupt))
+	lines = append(lines, s)
+	return strings.Join(lines, "\n")
+}
+
+func deleteUnusedImages(r *imageCacheReport) {
+	for _, path := range r.unused {
+		err := os.Remove(path)
+		if err != nil {
+			fmt.Printf("os.Remove(%s) failed with %s\n", path, err)
+			continue
+		}
+		logVerbose("deleted %s\n", path)
+	}
+	fmt.Printf("Deleted %d unused images\n", len(r.unused))
+}
+
+// gcImages reports unused and corrupt images in notion cache and, if
+// deleteUnused is true, deletes unused images. Returns false if there are
+// corrupt images
+func gcImages(c *notionapi.Client, deleteUnused bool) bool {
+	store := loadArticles(contentSources(c))
+	// if some pages are missing, we don't know all used images
+	complete := len(store.articles) > 0
+	if flgOffline {
+		if err := offlineCheckMissing(); err != nil {
+			fmt.Printf("%s\n", err)
+			complete = false
+		}
+	}
+	dirs := []string{filepath.Join(cacheDir, "img"), imageVariantsDir}
+	report := checkImageCache(dirs, usedImagePaths(store))
+	fmt.Printf("%s\n", report)
+	if deleteUnused {
+		if !complete {
+			fmt.Printf("Not deleting unused images because not all articles were loaded\n")
+			return false
+		}
+		deleteUnusedImages(report)
+	}
+	return len(report.corrupt) == 0
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckImageCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image_gc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	used := filepath.Join(dir, "used.png")
+	writeTestImage(t, used, 10, 10)
+	unused := filepath.Join(dir, "unused.jpg")
+	writeTestImage(t, unused, 10, 10)
+	truncated := filepath.Join(dir, "truncated.png")
+	d, err := ioutil.ReadFile(used)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(truncated, d[:len(d)/2], 0644)
+	assert.NoError(t, err)
+	pdf := filepath.Join(dir, "doc.pdf")
+	err = ioutil.WriteFile(pdf, []byte("%PDF-1.4"), 0644)
+	assert.NoError(t, err)
+
+	usedPaths := map[string]bool{
+		used:      true,
+		truncated: true,
+		pdf:       true,
+	}
+	dirs := []string{dir, filepath.Join(dir, "missing")}
+	r := checkImageCache(dirs, usedPaths)
+	assert.Equal(t, 4, r.nFiles)
+	assert.Equal(t, []string{unused}, r.unused)
+	assert.Equal(t, 1, len(r.corrupt))
+	assert.NotEqual(t, "", r.corrupt[truncated])
+
+	deleteUnusedImages(r)
+	_, err = os.Stat(unused)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(used)
+	assert.NoError(t, err)
+}
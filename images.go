@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Images in articles are served in several sizes so that browsers can
+// pick the smallest one that looks good (srcset). Resized variants are
+// generated once and cached in notion_cache/img_variants.
+// There's no pure Go WebP encoder so variants of WebP images are PNGs.
+// Animated gifs and svgs are not resized.
+// Images and their variants are served from /img/ under names made from
+// sha1 of the image content, so a changed image gets a different url and
+// the urls can be cached forever.
+
+// widths of resized variants. Only variants smaller than the original
+// are generated
+var imageVariantWidths = []int{480, 960, 1920}
+
+// images are shown at most this wide, see div#post in main.css
+const imageMaxDisplayWidth = 960
+
+var imageVariantsDir = filepath.Join(cacheDir, "img_variants")
+
+const jpegQuality = 85
+
+var (
+	imageURLsMu sync.Mutex
+	// path, size and modification time => url of the image
+	imageURLs = map[string]string{}
+)
+
+type imageInfo struct {
+	// "png", "jpeg", "gif", "webp" or "svg"
+	Format string
+	Width  int
+	Height int
+}
+
+// imageVariant is a resized version of an image
+type imageVariant struct {
+	path        string
+	relativeURL string
+	width       int
+}
+
+// imageURL returns url in /img/ of image (or other file we host, like pdf)
+// at path, named by sha1 of its content
+func imageURL(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	st, err := os.Stat(path)
+	if err != nil {
+		// images missing in offline mode are reported elsewhere
+		return "/img/" + strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ext
+	}
+	key := fmt.Sprintf("%s %d %s", path, st.Size(), st.ModTime())
+	imageURLsMu.Lock()
+	defer imageURLsMu.Unlock()
+	if uri, ok := imageURLs[key]; ok {
+		return uri
+	}
+	d, err := ioutil.ReadFile(path)
+	panicIfErr(err)
+	uri := "/img/" + hashBytes(d) + ext
+	imageURLs[key] = uri
+	return uri
+}
+
+// svgSize parses size like "120", "120px" or "120.5"
+func svgSize(s string) int {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "px")
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return int(f + 0.5)
+}
+
+// readSVGInfo returns size of svg image from width and height attributes
+// or, if they're missing or relative, from viewBox
+func readSVGInfo(r io.Reader) (*imageInfo, error) {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("no <svg> element: %s", err)
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if el.Name.Local != "svg" {
+			return nil, fmt.Errorf("root element is <%s> and not <svg>", el.Name.Local)
+		}
+		res := &imageInfo{Format: "svg"}
+		var viewBox []string
+		for _, attr := range el.Attr {
+			switch attr.Name.Local {
+			case "width":
+				res.Width = svgSize(attr.Value)
+			case "height":
+				res.Height = svgSize(attr.Value)
+			case "viewBox":
+				viewBox = strings.Fields(strings.Replace(attr.Value, ",", " ", -1))
+			}
+		}
+		if (res.Width == 0 || res.Height == 0) && len(viewBox) == 4 {
+			res.Width = svgSize(viewBox[2])
+			res.Height = svgSize(viewBox[3])
+		}
+		if res.Width == 0 || res.Height == 0 {
+			return nil, fmt.Errorf("svg has no size")
+		}
+		return res, nil
+	}
+}
+
+func readImageInfo(path string) (*imageInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.ToLower(filepath.Ext(path)) == ".svg" {
+		return readSVGInfo(f)
+	}
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	res := &imageInfo{
+		Format: format,
+		Width:  cfg.Width,
+		Height: cfg.Height,
+	}
+	return res, nil
+}
+
+func isAnimatedGIF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	g, err := gif.DecodeAll(f)
+	return err == nil && len(g.Image) > 1
+}
+
+// resizeImage writes a version of image at srcPath scaled to width
+func resizeImage(srcPath string, dstPath string, width int) error {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	b := src.Bounds()
+	height := (b.Dy()*width + b.Dx()/2) / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+
+	var buf bytes.Buffer
+	switch strings.ToLower(filepath.Ext(dstPath)) {
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	case ".gif":
+		err = gif.Encode(&buf, dst, nil)
+	default:
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dstPath), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dstPath, buf.Bytes(), 0644)
+}
+
+// isVariantStale returns true if variant doesn't exist or is older than
+// the image it was created from e.g. because the image was edited
+func isVariantStale(path string, variantPath string) bool {
+	vst, err := os.Stat(variantPath)
+	if err != nil {
+		return true
+	}
+	st, err := os.Stat(path)
+	return err != nil || vst.ModTime().Before(st.ModTime())
+}
+
+// imageVariants returns resized variants of image at path, generating the
+// ones that are not yet cached or are stale. relURL is url of the original
+// image and variants are named after it
+func imageVariants(path string, relURL string, info *imageInfo) []imageVariant {
+	if info.Format == "svg" || (info.Format == "gif" && isAnimatedGIF(path)) {
+		return nil
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if info.Format == "webp" {
+		ext = ".png"
+	}
+	base := strings.TrimSuffix(filepath.Base(relURL), filepath.Ext(relURL))
+	urlDir := strings.TrimSuffix(relURL, filepath.Base(relURL))
+	var res []imageVariant
+	for _, width := range imageVariantWidths {
+		if width >= info.Width {
+			break
+		}
+		name := fmt.Sprintf("%s-%dw%s", base, width, ext)
+		v := imageVariant{
+			path:        filepath.Join(imageVariantsDir, name),
+			relativeURL: urlDir + name,
+			width:       width,
+		}
+		if isVariantStale(path, v.path) {
+			err := resizeImage(path, v.path, width)
+			if err != nil {
+				fmt.Printf("imageVariants: failed to resize '%s' to width %d with '%s'\n", path, width, err)
+				return nil
+			}
+			logVerbose("imageVariants: created %s\n", v.path)
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+// imageSrcSet returns srcset attribute for the image and its variants
+func imageSrcSet(relURL string, info *imageInfo, variants []imageVariant) string {
+	if len(variants) == 0 {
+		return ""
+	}
+	var parts []string
+	for _, v := range variants {
+		parts = append(parts, fmt.Sprintf("%s %dw", v.relativeURL, v.width))
+	}
+	parts = append(parts, fmt.Sprintf("%s %dw", relURL, info.Width))
+	return strings.Join(parts, ", ")
+}
+
+// imageDisplaySize returns size at which the image is shown. maxWidth is
+// the width set by the author, if any
+func imageDisplaySize(info *imageInfo, maxWidth int) (int, int) {
+	w, h := info.Width, info.Height
+	if maxWidth > 0 && maxWidth < w {
+		h = (h*maxWidth + w/2) / w
+		w = maxWidth
+	}
+	return w, h
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestImage(t *testing.T, path string, w, h int) {
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	if strings.HasSuffix(path, ".jpg") {
+		err = jpeg.Encode(f, img, nil)
+	} else {
+		err = png.Encode(f, img)
+	}
+	assert.NoError(t, err)
+}
+
+func TestReadSVGInfo(t *testing.T) {
+	tests := []struct {
+		s    string
+		w, h int
+	}{
+		{`<svg xmlns="http://www.w3.org/2000/svg" width="120" height="80px"></svg>`, 120, 80},
+		{`<?xml version="1.0"?><svg width="100%" viewBox="0 0 64.4 32"></svg>`, 64, 32},
+	}
+	for _, test := range tests {
+		info, err := readSVGInfo(strings.NewReader(test.s))
+		assert.NoError(t, err)
+		assert.Equal(t, test.w, info.Width)
+		assert.Equal(t, test.h, info.Height)
+	}
+	_, err := readSVGInfo(strings.NewReader(`<svg></svg>`))
+	assert.Error(t, err)
+	_, err = readSVGInfo(strings.NewReader(`<html></html>`))
+	assert.Error(t, err)
+}
+
+func TestImageURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a.PNG")
+	b := filepath.Join(dir, "b.png")
+	writeTestImage(t, a, 100, 50)
+	writeTestImage(t, b, 100, 50)
+	d, err := ioutil.ReadFile(a)
+	assert.NoError(t, err)
+	uri := imageURL(a)
+	assert.Equal(t, "/img/"+hashBytes(d)+".png", uri)
+	// same content, same url
+	assert.Equal(t, uri, imageURL(b))
+
+	writeTestImage(t, b, 100, 60)
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(b, later, later)
+	assert.NoError(t, err)
+	assert.NotEqual(t, uri, imageURL(b))
+
+	assert.Equal(t, "/img/missing.jpg", imageURL(filepath.Join(dir, "missing.jpg")))
+}
+
+func TestImageVariants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	prevDir := imageVariantsDir
+	imageVariantsDir = filepath.Join(dir, "variants")
+	defer func() {
+		imageVariantsDir = prevDir
+	}()
+
+	path := filepath.Join(dir, "abc.jpg")
+	writeTestImage(t, path, 1000, 500)
+	info, err := readImageInfo(path)
+	assert.NoError(t, err)
+	assert.Equal(t, &imageInfo{Format: "jpeg", Width: 1000, Height: 500}, info)
+
+	variants := imageVariants(path, "/img/abc.jpg", info)
+	assert.Equal(t, 2, len(variants))
+	for _, v := range variants {
+		vi, err := readImageInfo(v.path)
+		assert.NoError(t, err)
+		assert.Equal(t, v.width, vi.Width)
+		assert.Equal(t, v.width/2, vi.Height)
+	}
+	assert.Equal(t, "/img/abc-480w.jpg 480w, /img/abc-960w.jpg 960w, /img/abc.jpg 1000w", imageSrcSet("/img/abc.jpg", info, variants))
+
+	// variants of edited image are re-generated
+	writeTestImage(t, path, 1000, 600)
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(path, later, later)
+	assert.NoError(t, err)
+	info, err = readImageInfo(path)
+	assert.NoError(t, err)
+	variants = imageVariants(path, "/img/abc.jpg", info)
+	assert.Equal(t, 2, len(variants))
+	vi, err := readImageInfo(variants[0].path)
+	assert.NoError(t, err)
+	assert.Equal(t, 288, vi.Height)
+
+	small := filepath.Join(dir, "small.png")
+	writeTestImage(t, small, 300, 200)
+	info, err = readImageInfo(small)
+	assert.NoError(t, err)
+	assert.Equal(t, "png", info.Format)
+	variants = imageVariants(small, "/img/small.png", info)
+	assert.Equal(t, 0, len(variants))
+	assert.Equal(t, "", imageSrcSet("/img/small.png", info, variants))
+
+	w, h := imageDisplaySize(&imageInfo{Width: 1000, Height: 500}, 300)
+	assert.Equal(t, []int{300, 150}, []int{w, h})
+	w, h = imageDisplaySize(&imageInfo{Width: 200, Height: 100}, 300)
+	assert.Equal(t, []int{200, 100}, []int{w, h})
+}
+
+func TestGuessExt(t *testing.T) {
+	tests := []struct {
+		fileName    string
+		contentType string
+		exp         string
+	}{
+		{"https://x.com/a.PNG", "", ".png"},
+		{"https://x.com/doc.pdf", "application/pdf", ".pdf"},
+		{"https://x.com/a?sig=1", "image/webp", ".webp"},
+		{"https://x.com/a", "image/svg+xml; charset=utf-8", ".svg"},
+		{"https://x.com/a", "image/gif", ".gif"},
+	}
+	for _, test := range tests {
+		got, err := guessExt(test.fileName, test.contentType)
+		assert.NoError(t, err)
+		assert.Equal(t, test.exp, got)
+	}
+	_, err := guessExt("https://x.com/a", "application/octet-stream")
+	assert.Error(t, err)
+}
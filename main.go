@@ -3,33 +3,53 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	_ "net/url"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
-	"time"
 
 	"github.com/kjk/notionapi"
 )
 
 var (
-	analyticsCode = "UA-194516-1"
-
 	flgRedownloadNotion bool
 	flgRedownloadPage   string
 	flgDeploy           bool
 	flgPreview          bool
 	flgVerbose          bool
+	flgOffline          bool
+	flgCleanBuild       bool
+	flgWatch            bool
+	flgLint             bool
+	flgGCImages         bool
+	flgGCImagesDelete   bool
+	flgOnlyUsedImages   bool
+	flgGCPages          bool
+	flgGCPagesDelete    bool
+	flgCheckRedirects   bool
+	flgPromoteRedirects bool
 )
 
 func parseCmdLineFlags() {
+	flag.StringVar(&siteConfigPath, "config", siteConfigPath, "site config file")
 	flag.BoolVar(&flgVerbose, "verbose", false, "if true, verbose logging")
 	flag.BoolVar(&flgDeploy, "deploy", false, "if true, build for deployment")
-	flag.BoolVar(&flgPreview, "preview", false, "if true, runs caddy and opens a browser for preview")
+	flag.BoolVar(&flgPreview, "preview", false, "if true, runs preview server and opens a browser")
+	flag.BoolVar(&flgCleanBuild, "clean", false, "if true, re-generates all files instead of only those that changed")
+	flag.BoolVar(&flgLint, "lint", false, "if true, reports problems with metadata of all articles")
+	flag.BoolVar(&flgGCImages, "gc-images", false, "if true, reports images in notion_cache not used by any article and images that don't decode")
+	flag.BoolVar(&flgGCImagesDelete, "gc-images-delete", false, "if true, deletes images in notion_cache not used by any article")
+	flag.BoolVar(&flgGCPages, "gc-pages", false, "if true, reports pages in notion_cache that are no longer reachable from the start page or were deleted in notion")
+	flag.BoolVar(&flgGCPagesDelete, "gc-pages-delete", false, "if true, deletes pages in notion_cache that are no longer reachable from the start page or were deleted in notion")
+	flag.BoolVar(&flgCheckRedirects, "check-redirects", false, "if true, builds the site, replays every url in redirects.txt and reports problems with redirects")
+	flag.BoolVar(&flgPromoteRedirects, "promote-redirects", false, "if true, does -check-redirects and changes redirects that work from 302 to 301 and redirects to deleted articles to 410 in redirects.txt")
+	flag.BoolVar(&flgOnlyUsedImages, "only-used-images", false, "if true, copies to netlify_static only images used by articles")
+	flag.BoolVar(&flgWatch, "watch", false, "if true, re-builds the site when files in www, articles or notion_cache change")
+	flag.BoolVar(&flgOffline, "offline", false, "if true, builds only from notion_cache without talking to notion")
 	flag.BoolVar(&flgRedownloadNotion, "redownload-notion", false, "if true, re-downloads content from notion")
 	flag.StringVar(&flgRedownloadPage, "redownload-page", "", "if given, redownloads content for one page")
+	flag.IntVar(&notionMaxConcurrentDownloads, "notion-concurrency", notionMaxConcurrentDownloads, "max number of pages downloaded from notion at the same time")
 	flag.Parse()
 }
 
@@ -40,26 +60,28 @@ func logVerbose(format string, args ...interface{}) {
 	fmt.Printf(format, args...)
 }
 
+func contentSources(c *notionapi.Client) []ContentSource {
+	return []ContentSource{
+		newNotionSource(c, site.NotionStartPage),
+		newMarkdownSource(mdArticlesDir),
+	}
+}
+
 func rebuildAll(c *notionapi.Client) {
 	regenMd()
 	loadTemplates()
-	articles := loadArticles(c)
+	articles := loadArticles(contentSources(c))
+	if flgOffline {
+		err := offlineCheckMissing()
+		if err != nil {
+			fmt.Printf("Offline build failed. %s\n", err)
+			os.Exit(1)
+		}
+	}
 	readRedirects(articles)
 	netlifyBuild(articles)
 }
 
-// caddy -log stdout
-func runCaddy() {
-	cmd := exec.Command("caddy", "-log", "stdout")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Run()
-}
-
-func stopCaddy(cmd *exec.Cmd) {
-	cmd.Process.Kill()
-}
-
 func openBrowser(url string) {
 	var err error
 
@@ -74,23 +96,121 @@ func openBrowser(url string) {
 		err = fmt.Errorf("unsupported platform")
 	}
 	if err != nil {
-		log.Fatal(err)
+		// not fatal, the user can open the url manually
+		fmt.Printf("Failed to open browser for %s: %s\n", url, err)
+	}
+}
+
+func gc(c *notionapi.Client) {
+	ok := true
+	// unused pages must be removed first so that their images are unused
+	if flgGCPages || flgGCPagesDelete {
+		ok = gcPages(c, []string{site.NotionStartPage}, flgGCPagesDelete)
+	}
+	if ok && (flgGCImages || flgGCImagesDelete) {
+		ok = gcImages(c, flgGCImagesDelete)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// checkRedirects builds the site and reports problems with redirects and
+// redirects that should change status code. With -promote-redirects it
+// also changes them in redirects.txt
+func checkRedirects(c *notionapi.Client) {
+	rebuildAll(c)
+	problems := redirectProblems
+	builtProblems, changes, err := checkBuiltRedirects("netlify_static")
+	panicIfErr(err)
+	problems = append(problems, builtProblems...)
+	for _, s := range problems {
+		fmt.Printf("%s: %s\n", redirectsPath, s)
+	}
+	for _, change := range changes {
+		fmt.Printf("%s: %s\n", redirectsPath, change)
+	}
+	fmt.Printf("Checked %d redirects: %d problems, %d should change status code\n", len(redirects), len(problems), len(changes))
+	if flgPromoteRedirects && len(changes) > 0 {
+		err = promoteRedirects(changes)
+		panicIfErr(err)
+		fmt.Printf("Changed status code of %d redirects in %s\n", len(changes), redirectsPath)
+	} else if len(changes) > 0 {
+		fmt.Printf("Run with -promote-redirects to change them in %s\n", redirectsPath)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
 	}
 }
 
 func preview() {
-	go func() {
-		time.Sleep(time.Second * 1)
-		openBrowser("http://localhost:8080")
-	}()
-	runCaddy()
+	runPreviewServer(previewAddr, "netlify_static")
+}
+
+func lint(c *notionapi.Client) {
+	ok := lintArticles(contentSources(c))
+	if flgOffline {
+		if err := offlineCheckMissing(); err != nil {
+			fmt.Printf("%s\n", err)
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// buildAndServe builds the site and, depending on flags, keeps re-building
+// it and serves it
+func buildAndServe(c *notionapi.Client) {
+	if !flgWatch {
+		rebuildAll(c)
+		if flgPreview {
+			preview()
+		}
+		return
+	}
+	w := newWatcher(c)
+	w.rebuild(rebuildEverything)
+	if flgPreview {
+		go w.run()
+		preview()
+		return
+	}
+	w.run()
 }
 
 func main() {
 	parseCmdLineFlags()
+	conf, err := loadSiteConfig(siteConfigPath)
+	if err != nil {
+		fmt.Printf("Failed to load site config. %s\n", err)
+		os.Exit(1)
+	}
+	setSiteConfig(conf)
 	os.MkdirAll("netlify_static", 0755)
 
 	client := &notionapi.Client{}
+	if flgOffline {
+		if flgRedownloadNotion || flgRedownloadPage != "" {
+			fmt.Printf("Can't re-download from notion with -offline\n")
+			os.Exit(1)
+		}
+		if flgLint {
+			lint(client)
+			return
+		}
+		if flgGCImages || flgGCImagesDelete || flgGCPages || flgGCPagesDelete {
+			gc(client)
+			return
+		}
+		if flgCheckRedirects || flgPromoteRedirects {
+			checkRedirects(client)
+			return
+		}
+		buildAndServe(client)
+		return
+	}
+
 	authToken, ok := os.LookupEnv("NOTION_TOKEN")
 	if !ok || strings.TrimSpace(authToken) == "" {
 		fmt.Printf("Must set NOTION_TOKEN env variable!\n")
@@ -105,6 +225,21 @@ func main() {
 		return
 	}
 
+	if flgLint {
+		lint(client)
+		return
+	}
+
+	if flgGCImages || flgGCImagesDelete || flgGCPages || flgGCPagesDelete {
+		gc(client)
+		return
+	}
+
+	if flgCheckRedirects || flgPromoteRedirects {
+		checkRedirects(client)
+		return
+	}
+
 	if flgRedownloadPage != "" {
 		notionRedownloadOne(client, flgRedownloadPage)
 		os.Exit(0)
@@ -120,8 +255,5 @@ func main() {
 		os.Exit(0)
 	}
 
-	rebuildAll(client)
-	if flgPreview {
-		preview()
-	}
+	buildAndServe(client)
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+)
+
+var (
+	// directory with articles written in markdown, kept in git
+	mdArticlesDir = "articles"
+)
+
+// markdownSource is a ContentSource for .md files in a directory.
+// A file starts with metadata lines, using the same keys as notion
+// pages, followed by an empty line and markdown body e.g.:
+//
+// Title: My article
+// PublishedOn: 2019-03-26
+// Tags: go, programming
+//
+// Relative image links are resolved relative to .md file.
+type markdownSource struct {
+	dir string
+}
+
+func newMarkdownSource(dir string) *markdownSource {
+	return &markdownSource{
+		dir: dir,
+	}
+}
+
+// LoadArticles loads all .md files in the directory. Missing directory
+// is not an error
+func (s *markdownSource) LoadArticles() ([]*Article, error) {
+	if _, err := os.Stat(s.dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+	files, err := getFilesRecur(s.dir, isMarkdownFile)
+	if err != nil {
+		return nil, err
+	}
+	var res []*Article
+	for _, path := range files {
+		article, err := loadMarkdownArticle(path)
+		if err != nil {
+			return nil, fmt.Errorf("loadMarkdownArticle('%s') failed with '%s'", path, err)
+		}
+		res = append(res, article)
+	}
+	fmt.Printf("Loaded %d markdown articles from %s\n", len(res), s.dir)
+	return res, nil
+}
+
+// RenderArticles does nothing because markdown articles are converted to
+// html when loaded
+func (s *markdownSource) RenderArticles(store *Articles) error {
+	return nil
+}
+
+func isRelativeURL(uri string) bool {
+	return !strings.Contains(uri, "://") && !strings.HasPrefix(uri, "/") && !strings.HasPrefix(uri, "data:")
+}
+
+// warnBadEquation prints a warning if we can't render equation, which is
+// then shown as TeX source
+func warnBadEquation(path string, tex []byte) {
+	if _, err := texToMathML(string(tex), false); err != nil {
+		fmt.Printf("%s: can't render equation '%s'. %s\n", path, string(tex), err)
+	}
+}
+
+func loadMarkdownArticle(path string) (*Article, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	md, meta := parseMd(d)
+
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	article := &Article{
+		ID:         urlify(name),
+		Title:      name,
+		sourcePath: path,
+	}
+	// parseMd returns a map, sort keys so that problems are reported
+	// in a stable order
+	var keys []string
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var lines []metaLine
+	for _, k := range keys {
+		if strings.ToLower(k) == "title" {
+			article.Title = meta[k]
+			continue
+		}
+		lines = append(lines, metaLine{key: k, val: meta[k]})
+	}
+	applyArticleMeta(article, lines)
+	// we can't use modification time of the file because it's the time
+	// of git checkout
+	if article.PublishedOn.IsZero() {
+		article.addMetaProblem(false, "'publishedon' is required in markdown articles")
+	}
+	if article.UpdatedOn.IsZero() {
+		article.UpdatedOn = article.PublishedOn
+	}
+	if article.Collection != "" {
+		urlPath := URLPath{
+			Name: article.Collection,
+			URL:  article.CollectionURL,
+		}
+		article.Paths = append(article.Paths, urlPath)
+	}
+
+	// rewrite relative image links to images we copy to /img/ and check
+	// that we can render equations
+	doc := markdown.Parse(md, newArticleMarkdownParser())
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch math := node.(type) {
+		case *ast.Math:
+			warnBadEquation(path, math.Literal)
+		case *ast.MathBlock:
+			if entering {
+				warnBadEquation(path, math.Literal)
+			}
+		}
+		img, ok := node.(*ast.Image)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		uri := string(img.Destination)
+		if !isRelativeURL(uri) {
+			return ast.GoToNext
+		}
+		imgPath := filepath.Join(filepath.Dir(path), filepath.FromSlash(uri))
+		if _, err := os.Stat(imgPath); err != nil {
+			fmt.Printf("%s: image '%s' doesn't exist\n", path, imgPath)
+			return ast.GoToNext
+		}
+		relURL := imageURL(imgPath)
+		im := ImageMapping{
+			path:        imgPath,
+			relativeURL: relURL,
+		}
+		article.Images = append(article.Images, im)
+		img.Destination = []byte(relURL)
+		return ast.GoToNext
+	})
+	article.TOC = markdownSetHeadingIDs(doc)
+	unsafe := markdown.Render(doc, newMarkdownHTMLRenderer(""))
+	article.BodyHTML = sanitizeHTML(unsafe)
+	article.HTMLBody = template.HTML(article.BodyHTML)
+	return article, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestMarkdownDir writes a markdown article with a local image
+// and returns the directory and path of the image
+func writeTestMarkdownDir(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "md_source")
+	assert.NoError(t, err)
+
+	md := `Title: Hello from git
+Id: hello-git
+Date: 2019-03-26
+Tags: Go, programming
+Description: a test
+
+Some *text*, $E = mc^2$ and ` + "`$HOME`" + `.
+
+$$\sum_{i=1}^n i$$
+
+![screenshot](img/shot.png)
+![remote](https://example.com/a.png)
+`
+	err = ioutil.WriteFile(filepath.Join(dir, "hello.md"), []byte(md), 0644)
+	assert.NoError(t, err)
+	imgPath := filepath.Join(dir, "img", "shot.png")
+	err = os.MkdirAll(filepath.Dir(imgPath), 0755)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(imgPath, []byte("not really png"), 0644)
+	assert.NoError(t, err)
+	return dir, imgPath
+}
+
+func TestMarkdownSource(t *testing.T) {
+	dir, imgPath := writeTestMarkdownDir(t)
+	defer os.RemoveAll(dir)
+
+	articles, err := newMarkdownSource(dir).LoadArticles()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(articles))
+	a := articles[0]
+	assert.Equal(t, "hello-git", a.ID)
+	assert.Equal(t, "Hello from git", a.Title)
+	assert.Equal(t, []string{"go", "programming"}, a.Tags)
+	assert.Equal(t, "a test", a.Description)
+	assert.Equal(t, "2019-03-26", a.PublishedOn.Format("2006-01-02"))
+	assert.True(t, a.IsBlog())
+	assert.True(t, strings.Contains(a.BodyHTML, "<em>text</em>"))
+	assert.Contains(t, a.BodyHTML, `<span class="katex"><math xmlns="http://www.w3.org/1998/Math/MathML"><semantics><mrow><mi>E</mi><mo>=</mo>`)
+	assert.Contains(t, a.BodyHTML, `<div class="equation"><span class="katex-display"><span class="katex"><math xmlns="http://www.w3.org/1998/Math/MathML" display="block"><semantics><mrow><munderover><mo>∑</mo>`)
+	assert.Contains(t, a.BodyHTML, "<code>$HOME</code>")
+
+	assert.Equal(t, 1, len(a.Images))
+	im := a.Images[0]
+	assert.Equal(t, imgPath, im.path)
+	assert.True(t, strings.Contains(a.BodyHTML, `src="`+im.relativeURL+`"`))
+	assert.True(t, strings.Contains(a.BodyHTML, `src="https://example.com/a.png"`))
+}
+
+func TestLoadArticlesMarkdown(t *testing.T) {
+	setTestSiteConfig()
+	dir, _ := writeTestMarkdownDir(t)
+	defer os.RemoveAll(dir)
+
+	store := loadArticles([]ContentSource{newMarkdownSource(dir)})
+	assert.Equal(t, 1, len(store.articles))
+	a := store.idToArticle["hello-git"]
+	if assert.NotNil(t, a) {
+		assert.Equal(t, 0, len(a.Paths))
+	}
+	assert.Equal(t, []*Article{a}, store.blog)
+}
+
+func TestMarkdownSourceMissingDir(t *testing.T) {
+	articles, err := newMarkdownSource(filepath.Join("testdata", "does-not-exist")).LoadArticles()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(articles))
+}
+
+func TestMarkdownArticleDates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "md_source")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "no-date.md")
+	err = ioutil.WriteFile(path, []byte("Title: No date\n\nText.\n"), 0644)
+	assert.NoError(t, err)
+	a, err := loadMarkdownArticle(path)
+	assert.NoError(t, err)
+	assert.True(t, a.PublishedOn.IsZero())
+	if assert.Equal(t, 1, len(a.metaProblems)) {
+		assert.Equal(t, "error: 'publishedon' is required in markdown articles", a.metaProblems[0].String())
+	}
+
+	path = filepath.Join(dir, "dated.md")
+	err = ioutil.WriteFile(path, []byte("Title: Dated\nPublishedOn: 2019-03-26\nTags: go\n\nText.\n"), 0644)
+	assert.NoError(t, err)
+	a, err = loadMarkdownArticle(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(a.metaProblems))
+	assert.Equal(t, a.PublishedOn, a.UpdatedOn)
+}
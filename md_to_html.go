@@ -12,7 +12,6 @@ import (
 	"github.com/gomarkdown/markdown/ast"
 	mdhtml "github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
-	"github.com/microcosm-cc/bluemonday"
 )
 
 var (
@@ -50,8 +49,26 @@ func htmlHighlight(w io.Writer, source, lang, defaultLang string) error {
 	return htmlFormatter.Format(w, highlightStyle, it)
 }
 
-func makeRenderHookCodeBlock(defaultLang string) mdhtml.RenderNodeFunc {
+func makeRenderHook(defaultLang string) mdhtml.RenderNodeFunc {
 	return func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		// headings get ids in markdownSetHeadingIDs
+		if hdr, ok := node.(*ast.Heading); ok && hdr.HeadingID != "" {
+			renderHeadingWithPermalink(w, hdr, entering)
+			return ast.GoToNext, true
+		}
+		// equations are only parsed in markdown articles, see newArticleMarkdownParser
+		switch math := node.(type) {
+		case *ast.Math:
+			s, _ := equationHTML(string(math.Literal), false)
+			io.WriteString(w, s)
+			return ast.GoToNext, true
+		case *ast.MathBlock:
+			if entering {
+				s, _ := equationHTML(string(math.Literal), true)
+				io.WriteString(w, `<div class="equation">`+s+"</div>\n")
+			}
+			return ast.GoToNext, true
+		}
 		codeBlock, ok := node.(*ast.CodeBlock)
 		if !ok {
 			return ast.GoToNext, false
@@ -69,34 +86,43 @@ func makeRenderHookCodeBlock(defaultLang string) mdhtml.RenderNodeFunc {
 	}
 }
 
-func markdownToUnsafeHTML(md []byte, defaultLang string) []byte {
-	extensions := parser.NoIntraEmphasis |
-		parser.Tables |
-		parser.FencedCode |
-		parser.Autolink |
-		parser.Strikethrough |
-		parser.SpaceHeadings |
-		parser.NoEmptyLineBeforeBlock
-	parser := parser.NewWithExtensions(extensions)
+const markdownExtensions = parser.NoIntraEmphasis |
+	parser.Tables |
+	parser.FencedCode |
+	parser.Autolink |
+	parser.Strikethrough |
+	parser.SpaceHeadings |
+	parser.NoEmptyLineBeforeBlock
+
+func newMarkdownParser() *parser.Parser {
+	return parser.NewWithExtensions(markdownExtensions)
+}
 
+// newArticleMarkdownParser also parses $inline$ and $$display$$ equations.
+// We don't use it for www pages, where $ is more likely to be just a $
+func newArticleMarkdownParser() *parser.Parser {
+	return parser.NewWithExtensions(markdownExtensions | parser.MathJax)
+}
+
+func newMarkdownHTMLRenderer(defaultLang string) *mdhtml.Renderer {
 	htmlFlags := mdhtml.Smartypants |
 		mdhtml.SmartypantsFractions |
 		mdhtml.SmartypantsDashes |
 		mdhtml.SmartypantsLatexDashes
 	htmlOpts := mdhtml.RendererOptions{
 		Flags:          htmlFlags,
-		RenderNodeHook: makeRenderHookCodeBlock(defaultLang),
+		RenderNodeHook: makeRenderHook(defaultLang),
 	}
-	renderer := mdhtml.NewRenderer(htmlOpts)
+	return mdhtml.NewRenderer(htmlOpts)
+}
+
+func markdownToUnsafeHTML(md []byte, defaultLang string) []byte {
+	parser := newMarkdownParser()
+	renderer := newMarkdownHTMLRenderer(defaultLang)
 	return markdown.ToHTML(md, parser, renderer)
 }
 
 func markdownToHTML(d []byte, defaultLang string) string {
 	unsafe := markdownToUnsafeHTML(d, defaultLang)
-	policy := bluemonday.UGCPolicy()
-	policy.AllowStyling()
-	policy.RequireNoFollowOnFullyQualifiedLinks(false)
-	policy.RequireNoFollowOnLinks(false)
-	res := policy.SanitizeBytes(unsafe)
-	return string(res)
+	return sanitizeHTML(unsafe)
 }
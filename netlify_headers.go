@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// _headers tells Netlify what headers to send for files in netlify_static:
+// - images in /img/ are named by sha1 of their content (see imageURL) so
+//   they never change and can be cached forever
+// - html and feeds change with every build so they're cached briefly
+// - urls rewritten with 200 in _redirects (e.g. /tag/go) get headers of
+//   the file they're rewritten to, because Netlify applies headers based
+//   on the url, not the file it serves
+// - html pages also get security headers and Content-Security-Policy that
+//   allows what the page embeds (scripts, iframes, stylesheets, images from
+//   other sites). We find that by looking at the html we generated, so each
+//   page only allows sites it uses. Browsers upgrade http:// urls to https://
+//   and so does the policy
+// Headers for paths in site.Headers override what we generate.
+// Paths where all files get the same headers are merged into /dir/*
+
+const (
+	cacheControlImages = "public, max-age=31536000, immutable"
+	cacheControlShort  = "public, max-age=300"
+)
+
+var (
+	reCSPTag          = regexp.MustCompile(`(?is)<(script|iframe|img|link|audio|video|source)\b[^>]*>`)
+	reCSPTagURL       = regexp.MustCompile(`(?i)\s(?:src|href)\s*=\s*["']((?:https?:)?//[^"'\s/]+)`)
+	reCSPStylesheet   = regexp.MustCompile(`(?i)\srel\s*=\s*["']?stylesheet`)
+	reCSPInlineScript = regexp.MustCompile(`(?is)<script\b[^>]*>(.*?)</script>`)
+	// scripts loaded by inline scripts, like analytics.js
+	reCSPScriptURL = regexp.MustCompile(`["']((?:https?:)?//[a-zA-Z0-9.-]+)/[^"'\s<>]*\.js["']`)
+)
+
+// what kind of resource a tag loads
+var cspTagDirectives = map[string]string{
+	"script": "script-src",
+	"iframe": "frame-src",
+	"img":    "img-src",
+	"link":   "style-src",
+	"audio":  "media-src",
+	"video":  "media-src",
+	"source": "media-src",
+}
+
+type cspSource struct {
+	directive string
+	source    string
+}
+
+// what embeds from those hosts load in turn
+var cspExtraSources = map[string][]cspSource{
+	// analytics.js sends hits as images or with xhr
+	"www.google-analytics.com": {
+		{"img-src", "https://www.google-analytics.com"},
+		{"connect-src", "https://www.google-analytics.com"},
+	},
+	// gist embeds add their stylesheet
+	"gist.github.com": {
+		{"style-src", "https://github.githubassets.com"},
+	},
+}
+
+// templates have inline scripts (analytics, onload handlers) and pages
+// have inline styles (e.g. width of notion columns)
+var cspDirectives = []cspSource{
+	{"default-src", "'self'"},
+	{"script-src", "'self' 'unsafe-inline'"},
+	{"style-src", "'self' 'unsafe-inline'"},
+	{"img-src", "'self' data:"},
+	{"font-src", "'self'"},
+	{"connect-src", "'self'"},
+	{"media-src", "'self'"},
+	{"frame-src", "'self'"},
+	{"object-src", "'none'"},
+	{"base-uri", "'self'"},
+	{"frame-ancestors", "'self'"},
+	{"upgrade-insecure-requests", ""},
+}
+
+// cspSources collects sources from other sites used by pages
+type cspSources struct {
+	// host of the site, its urls are 'self'
+	selfHost string
+	// directive => source => true
+	sources map[string]map[string]bool
+}
+
+func newCSPSources(host string) *cspSources {
+	res := &cspSources{
+		sources: map[string]map[string]bool{},
+	}
+	if u, err := url.Parse(host); err == nil {
+		res.selfHost = u.Host
+	}
+	return res
+}
+
+// add adds source for uri, which is scheme and host e.g. https://example.com
+// or //example.com. http:// is loaded as https:// because of
+// upgrade-insecure-requests so it becomes https:// source
+func (s *cspSources) add(directive string, uri string) {
+	if strings.HasPrefix(uri, "//") {
+		uri = "https:" + uri
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Host == s.selfHost {
+		return
+	}
+	host := strings.ToLower(u.Host)
+	s.addSource(directive, "https://"+host)
+	for _, extra := range cspExtraSources[host] {
+		s.addSource(extra.directive, extra.source)
+	}
+}
+
+func (s *cspSources) addSource(directive string, source string) {
+	if s.sources[directive] == nil {
+		s.sources[directive] = map[string]bool{}
+	}
+	s.sources[directive][source] = true
+}
+
+// scanHTML adds sources used by html page
+func (s *cspSources) scanHTML(d []byte) {
+	for _, tag := range reCSPTag.FindAll(d, -1) {
+		m := reCSPTagURL.FindSubmatch(tag)
+		if m == nil {
+			continue
+		}
+		name := strings.ToLower(string(reCSPTag.FindSubmatch(tag)[1]))
+		if name == "link" && !reCSPStylesheet.Match(tag) {
+			// e.g. <link rel="alternate"> doesn't load anything
+			continue
+		}
+		s.add(cspTagDirectives[name], string(m[1]))
+	}
+	for _, script := range reCSPInlineScript.FindAllSubmatch(d, -1) {
+		for _, m := range reCSPScriptURL.FindAllSubmatch(script[1], -1) {
+			s.add("script-src", string(m[1]))
+		}
+	}
+}
+
+func (s *cspSources) String() string {
+	var parts []string
+	for _, d := range cspDirectives {
+		var extra []string
+		for source := range s.sources[d.directive] {
+			extra = append(extra, source)
+		}
+		sort.Strings(extra)
+		words := []string{d.directive}
+		if d.source != "" {
+			words = append(words, d.source)
+		}
+		parts = append(parts, strings.Join(append(words, extra...), " "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func isHTMLFile(uri string) bool {
+	return strings.HasSuffix(uri, ".html")
+}
+
+// fileHeaders returns headers we send for file with url path uri
+func fileHeaders(uri string, csp string) map[string]string {
+	if strings.HasPrefix(uri, "/img/") {
+		return map[string]string{
+			"Cache-Control": cacheControlImages,
+		}
+	}
+	switch path.Ext(uri) {
+	case ".xml", ".json":
+		// feeds, sitemap and search index
+		return map[string]string{
+			"Cache-Control":          cacheControlShort,
+			"X-Content-Type-Options": "nosniff",
+		}
+	case ".html":
+		return map[string]string{
+			"Cache-Control":           cacheControlShort,
+			"Content-Security-Policy": csp,
+			"X-Content-Type-Options":  "nosniff",
+			"X-Frame-Options":         "SAMEORIGIN",
+			"Referrer-Policy":         "strict-origin-when-cross-origin",
+		}
+	}
+	return nil
+}
+
+// matchesHeadersPath returns true if uri matches path from _headers, which
+// is either exact or ends with * e.g. /tools/*
+func matchesHeadersPath(uri string, pattern string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(uri, strings.TrimSuffix(pattern, "*"))
+	}
+	return uri == pattern
+}
+
+// applyHeadersOverrides applies headers from config. Empty value removes
+// a header. Overrides are applied in order of their paths
+func applyHeadersOverrides(uri string, headers map[string]string, overrides map[string]map[string]string) map[string]string {
+	var patterns []string
+	for pattern := range overrides {
+		if matchesHeadersPath(uri, pattern) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return headers
+	}
+	sort.Strings(patterns)
+	res := map[string]string{}
+	for k, v := range headers {
+		res[k] = v
+	}
+	for _, pattern := range patterns {
+		for k, v := range overrides[pattern] {
+			if v == "" {
+				delete(res, k)
+			} else {
+				res[k] = v
+			}
+		}
+	}
+	return res
+}
+
+func headersKey(headers map[string]string) string {
+	var lines []string
+	for k, v := range headers {
+		lines = append(lines, k+": "+v)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+type headersRule struct {
+	path    string
+	headers map[string]string
+}
+
+// mergeHeadersRules turns headers of files into rules for _headers. Files in
+// a directory get a single /dir/* rule if all files with headers have
+// the same headers. Files without headers don't matter
+func mergeHeadersRules(files map[string]map[string]string) []*headersRule {
+	// "" if files in dir don't have the same headers
+	dirKeys := map[string]string{}
+	isMixed := map[string]bool{}
+	for uri, headers := range files {
+		if len(headers) == 0 {
+			continue
+		}
+		key := headersKey(headers)
+		for dir := path.Dir(uri); ; dir = path.Dir(dir) {
+			if prev, ok := dirKeys[dir]; ok && prev != key {
+				isMixed[dir] = true
+			}
+			dirKeys[dir] = key
+			if dir == "/" {
+				break
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	var res []*headersRule
+	addRule := func(path string, headers map[string]string) {
+		if !seen[path] {
+			seen[path] = true
+			res = append(res, &headersRule{path, headers})
+		}
+	}
+	for uri, headers := range files {
+		if len(headers) == 0 {
+			continue
+		}
+		// the top-most directory with the same headers
+		top := ""
+		for dir := path.Dir(uri); !isMixed[dir]; dir = path.Dir(dir) {
+			top = dir
+			if dir == "/" {
+				break
+			}
+		}
+		switch {
+		case top == "/":
+			addRule("/*", headers)
+		case top != "":
+			addRule(top+"/*", headers)
+		default:
+			addRule(uri, headers)
+			if path.Base(uri) == "index.html" {
+				addRule(strings.TrimSuffix(uri, "index.html"), headers)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].path < res[j].path
+	})
+	return res
+}
+
+func formatNetlifyHeaders(rules []*headersRule) []byte {
+	lines := []string{"# generated by the blog build, don't edit"}
+	for _, r := range rules {
+		lines = append(lines, r.path)
+		var names []string
+		for name := range r.headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			lines = append(lines, fmt.Sprintf("  %s: %s", name, r.headers[name]))
+		}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// pageCSP returns Content-Security-Policy for html page in dir
+func pageCSP(dir string, name string, host string) string {
+	csp := newCSPSources(host)
+	d, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		fmt.Printf("pageCSP: failed to read %s: %s\n", name, err)
+	} else {
+		csp.scanHTML(d)
+	}
+	return csp.String()
+}
+
+// genNetlifyHeaders returns _headers for files in dir and urls rewritten
+// to them by redirects. files are paths relative to dir
+func genNetlifyHeaders(dir string, files []string, redirects []*netlifyRedirect, c *siteConfig) []byte {
+	// url path of html page => its csp
+	pageToCSP := map[string]string{}
+	fileToHeaders := map[string]map[string]string{}
+	for _, name := range files {
+		// _redirects, _headers
+		if strings.HasPrefix(name, "_") {
+			continue
+		}
+		uri := "/" + name
+		if isHTMLFile(name) {
+			pageToCSP[uri] = pageCSP(dir, name, c.Host)
+		}
+		headers := fileHeaders(uri, pageToCSP[uri])
+		fileToHeaders[uri] = applyHeadersOverrides(uri, headers, c.Headers)
+	}
+	// for rewrites to urls with placeholders or to pages that don't exist
+	// we don't know what the page embeds so it can't embed anything
+	defaultCSP := newCSPSources(c.Host).String()
+	for _, r := range redirects {
+		if r.code != 200 || isExternalURL(r.to) {
+			continue
+		}
+		// existing files are served instead of the rewrite
+		uri := escapeRedirectURL(r.from)
+		if _, ok := fileToHeaders[uri]; ok {
+			continue
+		}
+		// the page is found the same way as in netlifyResolver.findFile
+		page, cspHeader := r.to, defaultCSP
+		to := path.Clean("/" + r.to)
+		for _, uri := range []string{to, path.Join(to, "index.html"), to + ".html"} {
+			if csp, ok := pageToCSP[uri]; ok {
+				page, cspHeader = uri, csp
+				break
+			}
+		}
+		headers := fileHeaders(page, cspHeader)
+		fileToHeaders[uri] = applyHeadersOverrides(uri, headers, c.Headers)
+	}
+	return formatNetlifyHeaders(mergeHeadersRules(fileToHeaders))
+}
+
+// netlifyWriteHeaders writes _headers for files of the current build
+func netlifyWriteHeaders() {
+	var files []string
+	for name := range currManifest.Files {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	d := genNetlifyHeaders("netlify_static", files, allNetlifyRedirects(), site)
+	netlifyWriteFile("_headers", d)
+}
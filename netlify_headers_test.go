@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSPSources(t *testing.T) {
+	csp := newCSPSources("https://blog.kowalczyk.info")
+	csp.scanHTML([]byte(`<html><head>
+<link rel="alternate" type="application/atom+xml" href="https://blog.kowalczyk.info/atom.xml">
+<link rel="stylesheet" href="/css/main.css">
+<script>
+	(function(){ var s = document.createElement('script'); s.src = '//www.google-analytics.com/analytics.js'; })();
+</script>
+</head><body>
+<script src="https://gist.github.com/kjk/1234.js"></script>
+<script src="http://gist.github.com/kjk/5678.js"></script>
+<script src="http://code.jquery.com/jquery.js"></script>
+<iframe width="560" src="https://www.youtube.com/embed/abc" allowfullscreen></iframe>
+<img src="https://blog.kowalczyk.info/img/a.png">
+<img src='http://example.com/b.png'>
+<pre>&lt;script src="https://evil.com/x.js"&gt;</pre>
+</body></html>`))
+	exp := []string{
+		"default-src 'self'",
+		"script-src 'self' 'unsafe-inline' https://code.jquery.com https://gist.github.com https://www.google-analytics.com",
+		"style-src 'self' 'unsafe-inline' https://github.githubassets.com",
+		"img-src 'self' data: https://example.com https://www.google-analytics.com",
+		"font-src 'self'",
+		"connect-src 'self' https://www.google-analytics.com",
+		"media-src 'self'",
+		"frame-src 'self' https://www.youtube.com",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"frame-ancestors 'self'",
+		"upgrade-insecure-requests",
+	}
+	assert.Equal(t, exp, strings.Split(csp.String(), "; "))
+}
+
+func TestFileHeaders(t *testing.T) {
+	tests := []struct {
+		uri          string
+		cacheControl string
+	}{
+		{"/img/logo.png", cacheControlImages},
+		{"/img/2b2b3ee05b3e01304a6dcd6b55a9ae69c1e0b480-480w.png", cacheControlImages},
+		{"/article/2b2b3ee05b3e01304a6dcd6b55a9ae69c1e0b480-480w.png", ""},
+		{"/index.html", cacheControlShort},
+		{"/article/00d9149180e8429e9579436281717fa7.html", cacheControlShort},
+		{"/atom.xml", cacheControlShort},
+		{"/feed.json", cacheControlShort},
+		{"/css/main.css", ""},
+		{"/ping", ""},
+	}
+	for _, test := range tests {
+		h := fileHeaders(test.uri, "default-src 'self'")
+		assert.Equal(t, test.cacheControl, h["Cache-Control"], "%s", test.uri)
+	}
+	assert.Equal(t, "default-src 'self'", fileHeaders("/index.html", "default-src 'self'")["Content-Security-Policy"])
+	assert.Equal(t, "", fileHeaders("/atom.xml", "default-src 'self'")["Content-Security-Policy"])
+}
+
+func TestApplyHeadersOverrides(t *testing.T) {
+	overrides := map[string]map[string]string{
+		"/tools/*":             {"X-Frame-Options": "", "X-Robots-Tag": "noindex"},
+		"/tools/embed.html":    {"X-Robots-Tag": "all"},
+		"/tools/embed.html/x/": {"X-Robots-Tag": "none"},
+	}
+	headers := map[string]string{"X-Frame-Options": "SAMEORIGIN"}
+	got := applyHeadersOverrides("/tools/embed.html", headers, overrides)
+	assert.Equal(t, map[string]string{"X-Robots-Tag": "all"}, got)
+	assert.Equal(t, map[string]string{"X-Frame-Options": "SAMEORIGIN"}, headers)
+	got = applyHeadersOverrides("/tools.html", headers, overrides)
+	assert.Equal(t, headers, got)
+	got = applyHeadersOverrides("/tools/ping", nil, overrides)
+	assert.Equal(t, map[string]string{"X-Robots-Tag": "noindex"}, got)
+}
+
+func TestMergeHeadersRules(t *testing.T) {
+	short := map[string]string{"Cache-Control": cacheControlShort}
+	long := map[string]string{"Cache-Control": cacheControlImages}
+	files := map[string]map[string]string{
+		"/index.html":            short,
+		"/atom.xml":              short,
+		"/css/main.css":          nil,
+		"/img/a.png":             long,
+		"/img/b.png":             long,
+		"/article/a.html":        short,
+		"/article/b/index.html":  short,
+		"/software/index.html":   short,
+		"/software/logo.png":     long,
+		"/software/docs/a.html":  short,
+		"/software/docs/b.html":  short,
+		"/software/docs/big.pdf": nil,
+	}
+	var got []string
+	for _, r := range mergeHeadersRules(files) {
+		got = append(got, r.path+" "+r.headers["Cache-Control"])
+	}
+	exp := []string{
+		// "/" is also served from /index.html
+		"/ " + cacheControlShort,
+		"/article/* " + cacheControlShort,
+		"/atom.xml " + cacheControlShort,
+		"/img/* " + cacheControlImages,
+		"/index.html " + cacheControlShort,
+		"/software/ " + cacheControlShort,
+		"/software/docs/* " + cacheControlShort,
+		"/software/index.html " + cacheControlShort,
+		"/software/logo.png " + cacheControlImages,
+	}
+	assert.Equal(t, exp, got)
+
+	delete(files, "/img/a.png")
+	delete(files, "/img/b.png")
+	delete(files, "/software/logo.png")
+	rules := mergeHeadersRules(files)
+	if assert.Equal(t, 1, len(rules)) {
+		assert.Equal(t, "/*", rules[0].path)
+	}
+}
+
+func TestGenNetlifyHeaders(t *testing.T) {
+	dir := writeTestSite(t, "index.html", "atom.xml", "tools/a.html", "img/a.png")
+	defer os.RemoveAll(dir)
+	video := `<iframe src="https://www.youtube.com/embed/abc"></iframe>`
+	err := ioutil.WriteFile(filepath.Join(dir, "tools", "a.html"), []byte(video), 0644)
+	assert.NoError(t, err)
+	c := &siteConfig{
+		Host: "https://blog.kowalczyk.info",
+		Headers: map[string]map[string]string{
+			"/tools/*": {"X-Frame-Options": ""},
+		},
+	}
+	files := []string{"_redirects", "atom.xml", "img/a.png", "index.html", "tools/a.html"}
+	redirects := []*netlifyRedirect{
+		{from: "/index.html", to: "/", code: 302},
+		{from: "/tag/objective c", to: "/article/archives-by-tag-objective-c.html", code: 200},
+		{from: "/tag/objective c/atom.xml", to: "/feeds/tag/objective-c/atom.xml", code: 200},
+		{from: "/software/", to: "/article/abc.html", code: 200},
+		{from: "/tools/embed", to: "/tools/a.html", code: 200},
+		{from: "/favicon.ico", to: "/static/favicon.ico", code: 200},
+		{from: "/sumatra*", to: "https://www.sumatrapdfreader.org/:splat", code: 200},
+	}
+	got := string(genNetlifyHeaders(dir, files, redirects, c))
+	assert.True(t, strings.HasPrefix(got, "# generated by the blog build, don't edit\n/\n"), "%s", got)
+	assert.NotContains(t, got, "_redirects")
+	assert.Contains(t, got, "\n/img/*\n  Cache-Control: "+cacheControlImages+"\n")
+	assert.Contains(t, got, "\n/tools/*\n  Cache-Control: "+cacheControlShort+"\n  Content-Security-Policy: default-src 'self';")
+	// only the page with the video allows youtube. /tools/embed gets its csp
+	// so it's merged into /tools/*
+	assert.Equal(t, 1, strings.Count(got, "frame-src 'self' https://www.youtube.com;"))
+	assert.Contains(t, got, "\n/tools/*\n  Cache-Control: "+cacheControlShort+"\n  Content-Security-Policy: default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; font-src 'self'; connect-src 'self'; media-src 'self'; frame-src 'self' https://www.youtube.com;")
+	assert.NotContains(t, got, "/tools/embed")
+	assert.Contains(t, got, "\n/tag/objective%20c\n  Cache-Control: "+cacheControlShort+"\n  Content-Security-Policy: default-src 'self';")
+	assert.Contains(t, got, "\n/tag/objective%20c/*\n  Cache-Control: "+cacheControlShort+"\n  X-Content-Type-Options: nosniff\n")
+	assert.Contains(t, got, "\n/software/*\n  Cache-Control: "+cacheControlShort+"\n  Content-Security-Policy: default-src 'self';")
+	assert.NotContains(t, got, "favicon")
+	assert.NotContains(t, got, "sumatra")
+	// index.html, / and the 2 rewrites to html, but not /tools/embed
+	assert.Equal(t, 4, strings.Count(got, "X-Frame-Options: SAMEORIGIN"))
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// netlifyResolver resolves urls the same way Netlify does, using rules
+// from _redirects file and files in netlify_static directory:
+//   - if a file exists for the url, it's served, even if there is a matching
+//     rule (Netlify calls this shadowing), unless the rule is forced with !
+//   - otherwise the first matching rule wins
+//   - trailing slash is ignored when matching
+//   - :name matches a single path segment, * at the end matches the rest
+//     of the url and is available in the destination as :splat (without
+//     leading slash, so /foo* matches /foo/bar with :splat being bar)
+//   - for 200 (rewrite), 404 and 410 the destination is served with that
+//     status code, for 301 and 302 we redirect to the destination
+type netlifyResolver struct {
+	dir   string
+	rules []*netlifyRule
+}
+
+type netlifyRule struct {
+	*netlifyRedirect
+	re    *regexp.Regexp
+	names []string
+}
+
+// netlifyResolved is the result of resolving a url
+type netlifyResolved struct {
+	code int
+	// file to serve for 200 and 404
+	path string
+	// url to redirect to for 301 and 302
+	location string
+	// rule that matched, nil if we served a file directly
+	rule *netlifyRedirect
+}
+
+// parseNetlifyRedirects parses _redirects file
+func parseNetlifyRedirects(d []byte) ([]*netlifyRedirect, error) {
+	var res []*netlifyRedirect
+	d = normalizeNewlines(d)
+	lines := bytes.Split(d, []byte{'\n'})
+	for i, l := range lines {
+		s := strings.TrimSpace(string(l))
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		// like Netlify, fields are separated by any whitespace so spaces
+		// in urls must be percent-encoded
+		parts := strings.Fields(s)
+		if len(parts) != 2 && len(parts) != 3 {
+			return nil, fmt.Errorf("line %d: '%s' is not 'from to [code]'", i+1, s)
+		}
+		// rules have unescaped paths, the way they're matched
+		from, err := url.PathUnescape(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: '%s' is not a valid url", i+1, parts[0])
+		}
+		to := parts[1]
+		if !isExternalURL(to) {
+			if to, err = url.PathUnescape(to); err != nil {
+				return nil, fmt.Errorf("line %d: '%s' is not a valid url", i+1, parts[1])
+			}
+		}
+		r := &netlifyRedirect{
+			from: from,
+			to:   to,
+			code: 301,
+		}
+		if len(parts) == 3 {
+			code, err := strconv.Atoi(strings.TrimSuffix(parts[2], "!"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: '%s' is not a valid status code", i+1, parts[2])
+			}
+			r.code = code
+			r.force = strings.HasSuffix(parts[2], "!")
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// netlifyRulePattern returns regexp for rule's from and names of its
+// placeholders e.g. /article/:id/* => ^/article/([^/]+)/(.*)$, [id splat]
+// and /foo* => ^/foo/?(.*)$, [splat]
+func netlifyRulePattern(from string) (string, []string) {
+	from = trimTrailingSlash(from)
+	var names []string
+	splat := strings.HasSuffix(from, "*")
+	from = strings.TrimSuffix(from, "*")
+	parts := strings.Split(from, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") && len(part) > 1 {
+			names = append(names, part[1:])
+			parts[i] = `([^/]+)`
+			continue
+		}
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	s := "^" + strings.Join(parts, "/")
+	if splat {
+		names = append(names, "splat")
+		if !strings.HasSuffix(s, "/") {
+			s += `/?`
+		}
+		s += `(.*)`
+	}
+	return s + "$", names
+}
+
+func compileNetlifyRule(r *netlifyRedirect) (*netlifyRule, error) {
+	s, names := netlifyRulePattern(r.from)
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+	return &netlifyRule{
+		netlifyRedirect: r,
+		re:              re,
+		names:           names,
+	}, nil
+}
+
+func trimTrailingSlash(s string) string {
+	if len(s) > 1 {
+		return strings.TrimSuffix(s, "/")
+	}
+	return s
+}
+
+func newNetlifyResolver(dir string, redirects []*netlifyRedirect) (*netlifyResolver, error) {
+	res := &netlifyResolver{
+		dir: dir,
+	}
+	for _, r := range redirects {
+		rule, err := compileNetlifyRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("bad rule '%s': %s", r.from, err)
+		}
+		res.rules = append(res.rules, rule)
+	}
+	return res, nil
+}
+
+// newNetlifyResolverFromDir creates a resolver for a directory with
+// a site built for Netlify, using rules from its _redirects file
+func newNetlifyResolverFromDir(dir string) (*netlifyResolver, error) {
+	d, err := readFileIfExists(filepath.Join(dir, "_redirects"))
+	if err != nil {
+		return nil, err
+	}
+	redirects, err := parseNetlifyRedirects(d)
+	if err != nil {
+		return nil, err
+	}
+	return newNetlifyResolver(dir, redirects)
+}
+
+// newNetlifyResolverForBuild creates a resolver that uses redirects of
+// the last build, without reading them back from _redirects
+func newNetlifyResolverForBuild(dir string) (*netlifyResolver, error) {
+	return newNetlifyResolver(dir, allNetlifyRedirects())
+}
+
+func readFileIfExists(path string) ([]byte, error) {
+	d, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return d, err
+}
+
+// findFile returns a file in r.dir for url path, the way Netlify finds it:
+// exact file, index.html in a directory or the path with .html extension
+func (r *netlifyResolver) findFile(uri string) string {
+	isDir := strings.HasSuffix(uri, "/")
+	// path.Clean also makes sure we don't escape r.dir with ..
+	uri = strings.TrimPrefix(path.Clean("/"+uri), "/")
+	filePath := filepath.Join(r.dir, filepath.FromSlash(uri))
+	candidates := []string{filePath, filepath.Join(filePath, "index.html")}
+	if !isDir && uri != "" {
+		candidates = append(candidates, filePath+".html")
+	}
+	for _, p := range candidates {
+		st, err := os.Stat(p)
+		if err == nil && st.Mode().IsRegular() {
+			return p
+		}
+	}
+	return ""
+}
+
+func (r *netlifyResolver) match(uri string) (*netlifyRule, map[string]string) {
+	uri = trimTrailingSlash(uri)
+	for _, rule := range r.rules {
+		m := rule.re.FindStringSubmatch(uri)
+		if m == nil {
+			continue
+		}
+		vals := map[string]string{}
+		for i, name := range rule.names {
+			vals[name] = m[i+1]
+		}
+		return rule, vals
+	}
+	return nil, nil
+}
+
+// expand replaces :name placeholders in to with matched values
+func expandNetlifyPlaceholders(to string, vals map[string]string) string {
+	parts := strings.Split(to, "/")
+	for i, part := range parts {
+		if !strings.HasPrefix(part, ":") {
+			continue
+		}
+		// placeholder might be followed by extension e.g. :id.html
+		name := part[1:]
+		rest := ""
+		if idx := strings.IndexAny(name, ".?#"); idx != -1 {
+			name, rest = name[:idx], name[idx:]
+		}
+		if v, ok := vals[name]; ok {
+			parts[i] = v + rest
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func isExternalURL(uri string) bool {
+	return strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://")
+}
+
+// resolve returns how Netlify would respond to a request for url path uri
+func (r *netlifyResolver) resolve(uri string) *netlifyResolved {
+	rule, vals := r.match(uri)
+	if rule == nil || !rule.force {
+		if path := r.findFile(uri); path != "" {
+			return &netlifyResolved{
+				code: 200,
+				path: path,
+			}
+		}
+	}
+	if rule == nil {
+		return r.notFound()
+	}
+	to := expandNetlifyPlaceholders(rule.to, vals)
+	res := &netlifyResolved{
+		code: rule.code,
+		rule: rule.netlifyRedirect,
+	}
+	switch rule.code {
+	case 301, 302, 303, 307, 308:
+		res.location = to
+	case 200, 404, 410:
+		if isExternalURL(to) {
+			// Netlify proxies those, we don't
+			res.code = 502
+			res.location = to
+			return res
+		}
+		res.path = r.findFile(to)
+		if res.path == "" {
+			nf := r.notFound()
+			nf.rule = rule.netlifyRedirect
+			return nf
+		}
+	default:
+		res.code = 500
+	}
+	return res
+}
+
+// netlifyMaxRedirects is how many redirects we follow before giving up,
+// like browsers do
+const netlifyMaxRedirects = 10
+
+// follow resolves uri and follows redirects to local urls, like a browser
+// would. Returns the last response and urls we were redirected to.
+// Redirects to other sites are not followed
+func (r *netlifyResolver) follow(uri string) (*netlifyResolved, []string, error) {
+	var hops []string
+	start := uri
+	seen := map[string]bool{}
+	for {
+		if seen[trimTrailingSlash(uri)] {
+			path := append([]string{start}, hops...)
+			return nil, hops, fmt.Errorf("redirect loop %s", strings.Join(path, " => "))
+		}
+		seen[trimTrailingSlash(uri)] = true
+		res := r.resolve(uri)
+		if res.location == "" || isExternalURL(res.location) {
+			return res, hops, nil
+		}
+		if len(hops) == netlifyMaxRedirects {
+			return nil, hops, fmt.Errorf("more than %d redirects", netlifyMaxRedirects)
+		}
+		uri = res.location
+		hops = append(hops, uri)
+	}
+}
+
+func (r *netlifyResolver) notFound() *netlifyResolved {
+	return &netlifyResolved{
+		code: 404,
+		path: r.findFile("/404.html"),
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRedirects = `/article/:id/*	/article/:id.html	200
+/index.html	/	302
+/blog/	/	302
+/feed	/atom.xml	301
+/software/sumatrapdf*	https://www.sumatrapdfreader.org/:splat	302
+/tag/go	/article/archives-by-tag-go.html	200
+/tag/objective%20c /article/archives-by-tag-objective-c.html 200
+/say%20hi   /hello%20world.html
+/gone	/missing.html	200
+/about.html	/index.html	200!
+`
+
+func writeTestSite(t *testing.T, files ...string) string {
+	dir, err := ioutil.TempDir("", "netlify_resolve")
+	assert.NoError(t, err)
+	for _, name := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		assert.NoError(t, err)
+		err = ioutil.WriteFile(path, []byte(name), 0644)
+		assert.NoError(t, err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "_redirects"), []byte(testRedirects), 0644)
+	assert.NoError(t, err)
+	return dir
+}
+
+func TestNetlifyResolver(t *testing.T) {
+	dir := writeTestSite(t, "index.html", "atom.xml", "about.html", "article/abc.html", "article/archives-by-tag-go.html", "article/archives-by-tag-objective-c.html")
+	defer os.RemoveAll(dir)
+
+	r, err := newNetlifyResolverFromDir(dir)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		uri      string
+		code     int
+		file     string
+		location string
+	}{
+		{"/", 200, "index.html", ""},
+		// existing file shadows the rule
+		{"/index.html", 200, "index.html", ""},
+		// .html is optional
+		{"/about", 200, "about.html", ""},
+		// forced rule isn't shadowed by existing file
+		{"/about.html", 200, "index.html", ""},
+		{"/article/abc/some-title.html", 200, "article/abc.html", ""},
+		{"/article/nope/some-title.html", 404, "", ""},
+		// trailing slash doesn't matter
+		{"/blog", 302, "", "/"},
+		{"/feed/", 301, "", "/atom.xml"},
+		{"/software/sumatrapdf/download.html", 302, "", "https://www.sumatrapdfreader.org/download.html"},
+		{"/software/sumatrapdf", 302, "", "https://www.sumatrapdfreader.org/"},
+		{"/tag/go", 200, "article/archives-by-tag-go.html", ""},
+		{"/tag/objective c", 200, "article/archives-by-tag-objective-c.html", ""},
+		{"/say hi", 301, "", "/hello world.html"},
+		{"/gone", 404, "", ""},
+		{"/../../etc/passwd", 404, "", ""},
+	}
+	for _, test := range tests {
+		res := r.resolve(test.uri)
+		assert.Equal(t, test.code, res.code, "uri: %s", test.uri)
+		file := ""
+		if res.path != "" {
+			file, err = filepath.Rel(dir, res.path)
+			assert.NoError(t, err)
+			file = filepath.ToSlash(file)
+		}
+		assert.Equal(t, test.file, file, "uri: %s", test.uri)
+		assert.Equal(t, test.location, res.location, "uri: %s", test.uri)
+	}
+}
+
+func TestNetlifyResolverFollow(t *testing.T) {
+	dir := writeTestSite(t, "index.html", "atom.xml")
+	defer os.RemoveAll(dir)
+	rules := []*netlifyRedirect{
+		{from: "/a", to: "/b", code: 301},
+		{from: "/b/", to: "/feed", code: 302},
+		{from: "/feed", to: "/atom.xml", code: 301},
+		{from: "/sumatra", to: "https://www.sumatrapdfreader.org/", code: 302},
+		{from: "/loop1", to: "/loop2/", code: 302},
+		{from: "/loop2", to: "/loop1", code: 302},
+		{from: "/dead", to: "/missing.html", code: 302},
+		{from: "/deleted", to: "/index.html", code: 410},
+	}
+	r, err := newNetlifyResolver(dir, rules)
+	assert.NoError(t, err)
+
+	res, hops, err := r.follow("/a")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.code)
+	assert.Equal(t, filepath.Join(dir, "atom.xml"), res.path)
+	assert.Equal(t, []string{"/b", "/feed", "/atom.xml"}, hops)
+
+	res, hops, err = r.follow("/sumatra")
+	assert.NoError(t, err)
+	assert.Equal(t, 302, res.code)
+	assert.Equal(t, "https://www.sumatrapdfreader.org/", res.location)
+	assert.Equal(t, 0, len(hops))
+
+	res, _, err = r.follow("/dead")
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.code)
+
+	res, hops, err = r.follow("/deleted")
+	assert.NoError(t, err)
+	assert.Equal(t, 410, res.code)
+	assert.Equal(t, filepath.Join(dir, "index.html"), res.path)
+	assert.Equal(t, 0, len(hops))
+
+	_, _, err = r.follow("/loop1")
+	assert.EqualError(t, err, "redirect loop /loop1 => /loop2/ => /loop1")
+}
+
+func TestInjectLiveReload(t *testing.T) {
+	got := string(injectLiveReload([]byte("<html><body>hi</body></html>")))
+	assert.Equal(t, "<html><body>hi"+liveReloadScript+"</body></html>", got)
+	got = string(injectLiveReload([]byte("hi")))
+	assert.Equal(t, "hi"+liveReloadScript, got)
+}
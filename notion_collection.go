@@ -0,0 +1,726 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kjk/notionapi"
+)
+
+// Rendering of collection_view blocks i.e. notion databases shown as
+// a table, list or gallery.
+//
+// notionapi doesn't give us view's sort, filter and which properties are
+// shown in list and gallery views, so we get them from raw json of
+// loadPageChunk and cache them in notion_cache/views/${pageID}.json
+
+// notionDate is a date in notion. Unlike notionapi.Date it has end date
+// of date ranges
+type notionDate struct {
+	// "MMM DD, YYYY", "MM/DD/YYYY", "DD/MM/YYYY", "YYYY/MM/DD", "relative"
+	DateFormat string `json:"date_format"`
+	// "2018-07-12"
+	StartDate string `json:"start_date"`
+	// "09:00"
+	StartTime string `json:"start_time"`
+	EndDate   string `json:"end_date"`
+	EndTime   string `json:"end_time"`
+}
+
+// maps notion's date_format to Go's time format
+var notionDateFormats = map[string]string{
+	"MMM DD, YYYY": "Jan 2, 2006",
+	"MM/DD/YYYY":   "01/02/2006",
+	"DD/MM/YYYY":   "02/01/2006",
+	"YYYY/MM/DD":   "2006/01/02",
+}
+
+func formatDateAndTime(date string, timeOfDay string, format string) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	s := t.Format(format)
+	if timeOfDay != "" {
+		s += " " + timeOfDay
+	}
+	return s
+}
+
+func (d *notionDate) String() string {
+	format := notionDateFormats[d.DateFormat]
+	if format == "" {
+		format = "Jan 2, 2006"
+	}
+	s := formatDateAndTime(d.StartDate, d.StartTime, format)
+	if d.EndDate != "" {
+		s += " → " + formatDateAndTime(d.EndDate, d.EndTime, format)
+	}
+	return s
+}
+
+// propSegment is a part of value of a property, with its formatting.
+// In json it's [text, [[attr, value], [attr]...]]
+type propSegment struct {
+	text string
+	// "b", "i", "s", "c" (no value), "a" (link), "u" (user id),
+	// "p" (page id), "d" (date), "h" (color)
+	attrs map[string]interface{}
+}
+
+func (s *propSegment) attrString(attr string) string {
+	v, _ := s.attrs[attr].(string)
+	return v
+}
+
+func (s *propSegment) date() *notionDate {
+	v, ok := s.attrs["d"]
+	if !ok {
+		return nil
+	}
+	d, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var res notionDate
+	if json.Unmarshal(d, &res) != nil {
+		return nil
+	}
+	return &res
+}
+
+// parsePropSegments parses value of a property. Values with unexpected
+// shape are skipped
+func parsePropSegments(v interface{}) []*propSegment {
+	var res []*propSegment
+	a, _ := v.([]interface{})
+	for _, el := range a {
+		parts, _ := el.([]interface{})
+		if len(parts) == 0 {
+			continue
+		}
+		text, ok := parts[0].(string)
+		if !ok {
+			continue
+		}
+		seg := &propSegment{
+			text:  text,
+			attrs: map[string]interface{}{},
+		}
+		if len(parts) > 1 {
+			attrs, _ := parts[1].([]interface{})
+			for _, attr := range attrs {
+				kv, _ := attr.([]interface{})
+				if len(kv) == 0 {
+					continue
+				}
+				name, _ := kv[0].(string)
+				var val interface{}
+				if len(kv) > 1 {
+					val = kv[1]
+				}
+				seg.attrs[name] = val
+			}
+		}
+		res = append(res, seg)
+	}
+	return res
+}
+
+func propSegmentsText(segs []*propSegment) string {
+	var parts []string
+	for _, s := range segs {
+		if d := s.date(); d != nil {
+			parts = append(parts, d.StartDate)
+			continue
+		}
+		parts = append(parts, s.text)
+	}
+	return strings.TrimSpace(strings.Join(parts, ""))
+}
+
+func (g *HTMLGenerator) userName(userID string) string {
+	for _, u := range g.page.Users {
+		if u.ID == userID {
+			return strings.TrimSpace(u.GivenName + " " + u.FamilyName)
+		}
+	}
+	return "@" + userID
+}
+
+// articleLink returns a link to an article for notion page with a given id,
+// or just the title if the page is not an article
+func (g *HTMLGenerator) articleLink(pageID string, title string) string {
+	var article *Article
+	if g.idToArticle != nil {
+		article = g.idToArticle(normalizeID(pageID))
+	}
+	if article == nil {
+		return html.EscapeString(title)
+	}
+	if title == "" || title == "‣" {
+		title = article.Title
+	}
+	return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(article.URL()), html.EscapeString(title))
+}
+
+// propSegmentsHTML renders formatted text
+func (g *HTMLGenerator) propSegmentsHTML(segs []*propSegment) string {
+	s := ""
+	for _, seg := range segs {
+		var inner string
+		if d := seg.date(); d != nil {
+			inner = fmt.Sprintf(`<span class="date">%s</span>`, html.EscapeString(d.String()))
+		} else if userID := seg.attrString("u"); userID != "" {
+			inner = fmt.Sprintf(`<span class="user">%s</span>`, html.EscapeString(g.userName(userID)))
+		} else if pageID := seg.attrString("p"); pageID != "" {
+			inner = g.articleLink(pageID, seg.text)
+		} else if link := seg.attrString("a"); link != "" {
+			inner = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(g.maybeReplaceNotionLink(link)), html.EscapeString(seg.text))
+		} else {
+			inner = html.EscapeString(seg.text)
+		}
+		tags := []struct {
+			attr string
+			tag  string
+		}{
+			{"c", "code"}, {"s", "strike"}, {"i", "i"}, {"b", "b"},
+		}
+		for _, t := range tags {
+			if _, ok := seg.attrs[t.attr]; ok {
+				inner = "<" + t.tag + ">" + inner + "</" + t.tag + ">"
+			}
+		}
+		s += inner
+	}
+	return s
+}
+
+func selectOptionColor(col *notionapi.CollectionColumnInfo, value string) string {
+	for _, opt := range col.Options {
+		if opt.Value == value {
+			return opt.Color
+		}
+	}
+	return "default"
+}
+
+func chipHTML(col *notionapi.CollectionColumnInfo, value string) string {
+	color := urlify(selectOptionColor(col, value))
+	return fmt.Sprintf(`<span class="chip chip-%s">%s</span>`, color, html.EscapeString(value))
+}
+
+// propHTML renders value of property of a row according to its type
+func (g *HTMLGenerator) propHTML(col *notionapi.CollectionColumnInfo, row *notionapi.Block, prop string) string {
+	segs := parsePropSegments(row.Properties[prop])
+	text := propSegmentsText(segs)
+	switch col.Type {
+	case "title":
+		if g.idToArticle != nil && g.idToArticle(normalizeID(row.ID)) != nil {
+			return g.articleLink(row.ID, text)
+		}
+		return g.propSegmentsHTML(segs)
+	case "checkbox":
+		if text == "Yes" {
+			return `<span class="checkbox checkbox-on">☑</span>`
+		}
+		return `<span class="checkbox">☐</span>`
+	case "select":
+		if text == "" {
+			return ""
+		}
+		return chipHTML(col, text)
+	case "multi_select":
+		s := ""
+		for _, v := range strings.Split(text, ",") {
+			if v != "" {
+				s += chipHTML(col, v)
+			}
+		}
+		return s
+	case "url", "email", "phone_number":
+		if text == "" {
+			return ""
+		}
+		uri := text
+		if col.Type == "email" {
+			uri = "mailto:" + text
+		} else if col.Type == "phone_number" {
+			uri = "tel:" + text
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(uri), html.EscapeString(text))
+	case "file":
+		var links []string
+		for _, seg := range segs {
+			uri := seg.attrString("a")
+			if uri == "" {
+				uri = seg.text
+			}
+			links = append(links, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(uri), html.EscapeString(seg.text)))
+		}
+		return strings.Join(links, ", ")
+	case "relation":
+		var links []string
+		for _, seg := range segs {
+			// pages that are not articles are not published so we
+			// don't have a title to show
+			pageID := seg.attrString("p")
+			if pageID != "" && g.idToArticle != nil && g.idToArticle(normalizeID(pageID)) != nil {
+				links = append(links, g.articleLink(pageID, ""))
+			}
+		}
+		return strings.Join(links, ", ")
+	case "created_time":
+		return html.EscapeString(row.CreatedOn().Format("Jan 2, 2006"))
+	case "last_edited_time":
+		return html.EscapeString(row.UpdatedOn().Format("Jan 2, 2006"))
+	case "created_by":
+		return html.EscapeString(g.userName(row.CreatedBy))
+	case "last_edited_by":
+		return html.EscapeString(g.userName(row.LastEditedBy))
+	}
+	// text, number, date, person and types we don't know about
+	return g.propSegmentsHTML(segs)
+}
+
+// collectionViewSettings are parts of collection_view that notionapi
+// doesn't parse
+type collectionViewSettings struct {
+	ID     string               `json:"id"`
+	Query  *collectionViewQuery `json:"query,omitempty"`
+	Format struct {
+		ListProperties    []*notionapi.TableProperty `json:"list_properties,omitempty"`
+		GalleryProperties []*notionapi.TableProperty `json:"gallery_properties,omitempty"`
+	} `json:"format"`
+}
+
+type collectionViewQuery struct {
+	// "and" or "or"
+	FilterOperator string              `json:"filter_operator,omitempty"`
+	Filter         []*collectionFilter `json:"filter,omitempty"`
+	Sort           []*collectionSort   `json:"sort,omitempty"`
+}
+
+type collectionFilter struct {
+	Property string `json:"property"`
+	// e.g. "string_contains", "enum_is", "is_empty"
+	Comparator string      `json:"comparator"`
+	Value      interface{} `json:"value,omitempty"`
+}
+
+type collectionSort struct {
+	Property string `json:"property"`
+	// "ascending" or "descending"
+	Direction string `json:"direction"`
+}
+
+func collectionViewsCachePath(pageID string) string {
+	return filepath.Join(cacheDir, "views", normalizeID(pageID)+".json")
+}
+
+// removeCachedCollectionViews removes settings of collection views of a page,
+// so that they're downloaded again when rendering the page
+func removeCachedCollectionViews(pageID string) {
+	os.Remove(collectionViewsCachePath(pageID))
+}
+
+// downloadCollectionViews downloads settings of all collection views in a page
+func downloadCollectionViews(c *notionapi.Client, pageID string) (map[string]*collectionViewSettings, error) {
+	res := map[string]*collectionViewSettings{}
+	rsp, err := c.LoadPageChunk(pageID, 0, nil)
+	for chunkNo := 1; ; chunkNo++ {
+		if err != nil {
+			return nil, err
+		}
+		var raw struct {
+			RecordMap struct {
+				CollectionViews map[string]struct {
+					Value *collectionViewSettings `json:"value"`
+				} `json:"collection_view"`
+			} `json:"recordMap"`
+		}
+		err = json.Unmarshal(rsp.RawJSON, &raw)
+		if err != nil {
+			return nil, err
+		}
+		for id, v := range raw.RecordMap.CollectionViews {
+			if v.Value != nil {
+				res[normalizeID(id)] = v.Value
+			}
+		}
+		if len(rsp.Cursor.Stack) == 0 {
+			return res, nil
+		}
+		rsp, err = c.LoadPageChunk(pageID, chunkNo, &rsp.Cursor)
+	}
+}
+
+// loadCollectionViews returns settings of collection views in a page, from
+// cache or downloaded from notion. In offline mode (or without a client)
+// missing settings are reported by offlineCheckMissing and we return nil,
+// in which case views are shown unsorted and unfiltered
+func loadCollectionViews(c *notionapi.Client, pageID string) (map[string]*collectionViewSettings, error) {
+	path := collectionViewsCachePath(pageID)
+	var res map[string]*collectionViewSettings
+	d, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(d, &res)
+		if err == nil {
+			return res, nil
+		}
+		fmt.Printf("loadCollectionViews: failed to parse '%s' with '%s'\n", path, err)
+	}
+	if flgOffline || c == nil {
+		// build continues so that we can report everything that is missing
+		offlineAddMissingViews(normalizeID(pageID))
+		return nil, nil
+	}
+	res, err = downloadCollectionViews(c, normalizeID(pageID))
+	if err != nil {
+		return nil, fmt.Errorf("downloading collection views of page %s failed with '%s'", pageID, err)
+	}
+	d, err = json.MarshalIndent(res, "", "  ")
+	panicIfErr(err)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	panicIfErr(err)
+	err = writeFileAtomic(path, d)
+	panicIfErr(err)
+	return res, nil
+}
+
+func parseNumber(s string) (float64, bool) {
+	s = strings.Replace(strings.TrimSpace(s), ",", "", -1)
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// rowPropText returns value of a property, for filtering and sorting
+func rowPropText(row *notionapi.Block, prop string) string {
+	return propSegmentsText(parsePropSegments(row.Properties[prop]))
+}
+
+func filterValueString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		if v {
+			return "Yes"
+		}
+		return "No"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]interface{}:
+		// dates are {"type": "date", "start_date": "2019-03-26"}
+		s, _ := v["start_date"].(string)
+		return s
+	}
+	return ""
+}
+
+// matchesFilter returns false if row is filtered out. ok is false
+// if we don't understand the filter
+func matchesFilter(row *notionapi.Block, f *collectionFilter) (matches bool, ok bool) {
+	val := rowPropText(row, f.Property)
+	fv := filterValueString(f.Value)
+	lval, lfv := strings.ToLower(val), strings.ToLower(fv)
+	hasEnum := func() bool {
+		for _, s := range strings.Split(val, ",") {
+			if s == fv {
+				return true
+			}
+		}
+		return false
+	}
+	num, isNum := parseNumber(val)
+	fnum, isFilterNum := parseNumber(fv)
+	isNum = isNum && isFilterNum
+	checked := val == "Yes"
+	switch f.Comparator {
+	case "is_empty":
+		return val == "", true
+	case "is_not_empty":
+		return val != "", true
+	case "string_is":
+		return lval == lfv, true
+	case "string_is_not":
+		return lval != lfv, true
+	case "string_contains":
+		return strings.Contains(lval, lfv), true
+	case "string_does_not_contain":
+		return !strings.Contains(lval, lfv), true
+	case "string_starts_with":
+		return strings.HasPrefix(lval, lfv), true
+	case "string_ends_with":
+		return strings.HasSuffix(lval, lfv), true
+	case "enum_is", "enum_contains":
+		return hasEnum(), true
+	case "enum_is_not", "enum_does_not_contain":
+		return !hasEnum(), true
+	case "checkbox_is":
+		return checked == (fv == "Yes" || fv == "checked"), true
+	case "checkbox_is_not":
+		return checked != (fv == "Yes" || fv == "checked"), true
+	case "number_equals":
+		return isNum && num == fnum, true
+	case "number_does_not_equal":
+		return !isNum || num != fnum, true
+	case "number_greater_than":
+		return isNum && num > fnum, true
+	case "number_less_than":
+		return isNum && num < fnum, true
+	case "number_greater_than_or_equal_to":
+		return isNum && num >= fnum, true
+	case "number_less_than_or_equal_to":
+		return isNum && num <= fnum, true
+	// dates are "2006-01-02" so they compare like strings
+	case "date_is":
+		return val == fv, true
+	case "date_is_before":
+		return val != "" && val < fv, true
+	case "date_is_after":
+		return val > fv, true
+	}
+	return true, false
+}
+
+// filterRows returns rows that match the query
+func filterRows(rows []*notionapi.Block, q *collectionViewQuery) []*notionapi.Block {
+	if q == nil || len(q.Filter) == 0 {
+		return rows
+	}
+	isOr := q.FilterOperator == "or"
+	warned := map[string]bool{}
+	var res []*notionapi.Block
+	for _, row := range rows {
+		matches := !isOr
+		// if all filters are ignored, the row is shown
+		evaluated := false
+		for _, f := range q.Filter {
+			m, ok := matchesFilter(row, f)
+			if !ok {
+				if !warned[f.Comparator] {
+					fmt.Printf("Warning: collection view filter '%s' is not supported, ignoring it\n", f.Comparator)
+					warned[f.Comparator] = true
+				}
+				continue
+			}
+			evaluated = true
+			if isOr {
+				matches = matches || m
+			} else {
+				matches = matches && m
+			}
+		}
+		if matches || !evaluated {
+			res = append(res, row)
+		}
+	}
+	return res
+}
+
+// compareProps compares values of a property as numbers if both are
+// numbers and as case-insensitive strings otherwise
+func compareProps(a, b string) int {
+	na, okA := parseNumber(a)
+	nb, okB := parseNumber(b)
+	if okA && okB {
+		switch {
+		case na < nb:
+			return -1
+		case na > nb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// sortRows sorts rows in manual order of the view (pageSort) and
+// then by sort of the query
+func sortRows(rows []*notionapi.Block, pageSort []string, q *collectionViewQuery) {
+	pos := map[string]int{}
+	for i, id := range pageSort {
+		pos[normalizeID(id)] = i + 1
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		pi, pj := pos[normalizeID(rows[i].ID)], pos[normalizeID(rows[j].ID)]
+		// rows not in page sort are at the end
+		if pi == 0 || pj == 0 {
+			return pi != 0 && pj == 0
+		}
+		return pi < pj
+	})
+	if q == nil || len(q.Sort) == 0 {
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, s := range q.Sort {
+			c := compareProps(rowPropText(rows[i], s.Property), rowPropText(rows[j], s.Property))
+			if c == 0 {
+				continue
+			}
+			if s.Direction == "descending" {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// visibleProperties returns properties shown in the view, title first
+func visibleProperties(viewInfo *notionapi.CollectionViewInfo, settings *collectionViewSettings) []string {
+	view := viewInfo.CollectionView
+	var props []*notionapi.TableProperty
+	switch view.Type {
+	case "list":
+		if settings != nil {
+			props = settings.Format.ListProperties
+		}
+	case "gallery":
+		if settings != nil {
+			props = settings.Format.GalleryProperties
+		}
+	default:
+		if view.Format != nil {
+			props = view.Format.TableProperties
+		}
+	}
+	var res []string
+	if view.Type == "list" || view.Type == "gallery" {
+		// title is always shown in list and gallery and isn't in properties
+		res = append(res, "title")
+	}
+	schema := viewInfo.Collection.CollectionSchema
+	for _, p := range props {
+		if p.Visible && p.Property != "title" && schema[p.Property] != nil {
+			res = append(res, p.Property)
+		}
+		if p.Visible && p.Property == "title" && view.Type != "list" && view.Type != "gallery" {
+			res = append(res, p.Property)
+		}
+	}
+	if len(props) == 0 {
+		// we don't know which are visible so show all
+		var names []string
+		for name := range schema {
+			if name != "title" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		res = append([]string{"title"}, names...)
+	}
+	return res
+}
+
+func (g *HTMLGenerator) genCollectionView(block *notionapi.Block) {
+	if g.collectionViews == nil {
+		var err error
+		g.collectionViews, err = loadCollectionViews(g.notionClient, g.page.ID)
+		panicIfErr(err)
+		if g.collectionViews == nil {
+			// don't try again for other views in the page
+			g.collectionViews = map[string]*collectionViewSettings{}
+		}
+	}
+	for _, viewInfo := range block.CollectionViews {
+		view := viewInfo.CollectionView
+		settings := g.collectionViews[normalizeID(view.ID)]
+		var q *collectionViewQuery
+		if settings != nil {
+			q = settings.Query
+		}
+		rows := filterRows(viewInfo.CollectionRows, q)
+		rows = append([]*notionapi.Block(nil), rows...)
+		sortRows(rows, view.PageSort, q)
+
+		if len(block.CollectionViews) > 1 && view.Name != "" {
+			fmt.Fprintf(g.f, `<div class="notion-view-name">%s</div>`, html.EscapeString(view.Name))
+		}
+		props := visibleProperties(viewInfo, settings)
+		switch view.Type {
+		case "list":
+			g.genCollectionList(viewInfo, props, rows)
+		case "gallery":
+			g.genCollectionGallery(viewInfo, props, rows)
+		default:
+			// board and calendar views are shown as a table
+			g.genCollectionTable(viewInfo, props, rows)
+		}
+	}
+}
+
+func (g *HTMLGenerator) genCollectionTable(viewInfo *notionapi.CollectionViewInfo, props []string, rows []*notionapi.Block) {
+	schema := viewInfo.Collection.CollectionSchema
+	s := `<table class="notion-table"><thead><tr>`
+	for _, prop := range props {
+		s += `<th>` + html.EscapeString(schema[prop].Name) + `</th>`
+	}
+	s += `</tr></thead>`
+	s += `<tbody>`
+	for _, row := range rows {
+		s += `<tr>`
+		for _, prop := range props {
+			col := schema[prop]
+			v := g.propHTML(col, row, prop)
+			cls := ""
+			if col.Type == "number" {
+				cls = ` class="num"`
+			}
+			if v == "" {
+				// use &nbsp; so that empty row still shows up
+				v = "&nbsp;"
+			}
+			s += `<td` + cls + `>` + v + `</td>`
+		}
+		s += `</tr>`
+	}
+	s += `</tbody>`
+	s += `</table>`
+	g.writeString(s)
+}
+
+// rowPropsHTML renders non-empty properties other than title
+func (g *HTMLGenerator) rowPropsHTML(viewInfo *notionapi.CollectionViewInfo, props []string, row *notionapi.Block) string {
+	schema := viewInfo.Collection.CollectionSchema
+	s := ""
+	for _, prop := range props[1:] {
+		v := g.propHTML(schema[prop], row, prop)
+		if v != "" {
+			s += `<span class="notion-prop">` + v + `</span>`
+		}
+	}
+	return s
+}
+
+func (g *HTMLGenerator) genCollectionList(viewInfo *notionapi.CollectionViewInfo, props []string, rows []*notionapi.Block) {
+	schema := viewInfo.Collection.CollectionSchema
+	s := `<ul class="notion-list-view">`
+	for _, row := range rows {
+		title := g.propHTML(schema["title"], row, "title")
+		s += `<li><span class="notion-row-title">` + title + `</span>` + g.rowPropsHTML(viewInfo, props, row) + `</li>`
+	}
+	s += `</ul>`
+	g.writeString(s)
+}
+
+func (g *HTMLGenerator) genCollectionGallery(viewInfo *notionapi.CollectionViewInfo, props []string, rows []*notionapi.Block) {
+	schema := viewInfo.Collection.CollectionSchema
+	s := `<div class="notion-gallery">`
+	for _, row := range rows {
+		title := g.propHTML(schema["title"], row, "title")
+		s += `<div class="notion-gallery-card"><div class="notion-row-title">` + title + `</div>` + g.rowPropsHTML(viewInfo, props, row) + `</div>`
+	}
+	s += `</div>`
+	g.writeString(s)
+}
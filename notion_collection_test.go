@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kjk/notionapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotionDateString(t *testing.T) {
+	tests := []struct {
+		date notionDate
+		exp  string
+	}{
+		{notionDate{StartDate: "2019-03-26"}, "Mar 26, 2019"},
+		{notionDate{DateFormat: "YYYY/MM/DD", StartDate: "2019-03-26", StartTime: "09:00"}, "2019/03/26 09:00"},
+		{notionDate{DateFormat: "DD/MM/YYYY", StartDate: "2019-03-26", EndDate: "2019-04-02"}, "26/03/2019 → 02/04/2019"},
+		{notionDate{StartDate: "not a date"}, "not a date"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.exp, test.date.String())
+	}
+}
+
+func propsRow(id string, props string) *notionapi.Block {
+	row := &notionapi.Block{ID: id, Type: notionapi.BlockPage}
+	err := json.Unmarshal([]byte(props), &row.Properties)
+	if err != nil {
+		panic(err)
+	}
+	return row
+}
+
+func TestNotionPropHTML(t *testing.T) {
+	page := &notionapi.Page{
+		ID:    fakePageID(1),
+		Users: []*notionapi.User{{ID: "u1", GivenName: "Jane", FamilyName: "Doe"}},
+	}
+	g := NewHTMLGenerator(nil, page)
+	article := &Article{ID: fakePageID(2), Title: "Other", urlOverride: "/other.html"}
+	g.idToArticle = func(id string) *Article {
+		if id == article.ID {
+			return article
+		}
+		return nil
+	}
+	row := propsRow(fakePageID(3), `{
+		"title": [["Some ", [["b"]]], ["<row>"]],
+		"sel": [["fast"]],
+		"multi": [["a,b"]],
+		"check": [["Yes"]],
+		"url": [["https://example.com/?a=1&b=2"]],
+		"date": [["‣", [["d", {"type": "daterange", "start_date": "2019-03-26", "end_date": "2019-03-28"}]]]],
+		"who": [["‣", [["u", "u1"]]]],
+		"rel": [["‣", [["p", "`+fakePageID(2)+`"]]], [","], ["‣", [["p", "unknown"]]]],
+		"num": [["12"]]
+	}`)
+	col := func(typ string) *notionapi.CollectionColumnInfo {
+		return &notionapi.CollectionColumnInfo{
+			Type: typ,
+			Options: []*notionapi.CollectionColumnOption{
+				{Value: "fast", Color: "green"},
+			},
+		}
+	}
+	tests := []struct {
+		prop string
+		typ  string
+		exp  string
+	}{
+		{"title", "title", `<b>Some </b>&lt;row&gt;`},
+		{"sel", "select", `<span class="chip chip-green">fast</span>`},
+		{"multi", "multi_select", `<span class="chip chip-default">a</span><span class="chip chip-default">b</span>`},
+		{"check", "checkbox", `<span class="checkbox checkbox-on">☑</span>`},
+		{"missing", "checkbox", `<span class="checkbox">☐</span>`},
+		{"url", "url", `<a href="https://example.com/?a=1&amp;b=2">https://example.com/?a=1&amp;b=2</a>`},
+		{"date", "date", `<span class="date">Mar 26, 2019 → Mar 28, 2019</span>`},
+		{"who", "person", `<span class="user">Jane Doe</span>`},
+		{"rel", "relation", `<a href="/other.html">Other</a>`},
+		{"num", "number", `12`},
+	}
+	for _, test := range tests {
+		got := g.propHTML(col(test.typ), row, test.prop)
+		assert.Equal(t, test.exp, got, "prop: %s", test.prop)
+	}
+}
+
+func TestNotionFilterAndSortRows(t *testing.T) {
+	rows := []*notionapi.Block{
+		propsRow("r1", `{"title": [["b"]], "n": [["10"]], "tags": [["go,web"]]}`),
+		propsRow("r2", `{"title": [["a"]], "n": [["9"]], "tags": [["go"]]}`),
+		propsRow("r3", `{"title": [["c"]], "n": [["1,000"]]}`),
+	}
+	ids := func(rows []*notionapi.Block) []string {
+		var res []string
+		for _, r := range rows {
+			res = append(res, r.ID)
+		}
+		return res
+	}
+
+	q := &collectionViewQuery{
+		Filter: []*collectionFilter{
+			{Property: "tags", Comparator: "enum_contains", Value: "go"},
+			{Property: "n", Comparator: "number_greater_than", Value: "5"},
+			{Property: "n", Comparator: "some_new_comparator", Value: "5"},
+		},
+	}
+	assert.Equal(t, []string{"r1", "r2"}, ids(filterRows(rows, q)))
+	q.FilterOperator = "or"
+	q.Filter[0].Comparator = "is_empty"
+	q.Filter[1].Comparator = "string_is"
+	q.Filter[1].Value = "9"
+	assert.Equal(t, []string{"r2", "r3"}, ids(filterRows(rows, q)))
+	q.Filter = []*collectionFilter{
+		{Property: "tags", Comparator: "relation_contains", Value: "go"},
+		{Property: "n", Comparator: "some_new_comparator", Value: "5"},
+	}
+	assert.Equal(t, []string{"r1", "r2", "r3"}, ids(filterRows(rows, q)))
+
+	sorted := append([]*notionapi.Block(nil), rows...)
+	sortRows(sorted, []string{"r3", "r1"}, nil)
+	assert.Equal(t, []string{"r3", "r1", "r2"}, ids(sorted))
+	q = &collectionViewQuery{
+		Sort: []*collectionSort{{Property: "n", Direction: "descending"}},
+	}
+	sortRows(sorted, nil, q)
+	assert.Equal(t, []string{"r3", "r1", "r2"}, ids(sorted))
+	q.Sort[0] = &collectionSort{Property: "title", Direction: "ascending"}
+	sortRows(sorted, nil, q)
+	assert.Equal(t, []string{"r2", "r1", "r3"}, ids(sorted))
+}
+
+func TestLoadCollectionViewsOffline(t *testing.T) {
+	prevOffline := flgOffline
+	defer func() {
+		flgOffline = prevOffline
+		offlineResetMissing()
+	}()
+	flgOffline = true
+	offlineResetMissing()
+
+	views, err := loadCollectionViews(nil, fakePageID(9))
+	assert.NoError(t, err)
+	assert.Nil(t, views)
+	err = offlineCheckMissing()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "collection views of 1 pages are not in notion_cache")
+		assert.Contains(t, err.Error(), "  views "+fakePageID(9)+" https://notion.so/"+fakePageID(9))
+	}
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"crypto/sha1"
-	"encoding/json"
 	"fmt"
+	"html/template"
 	"io"
 	"io/ioutil"
+	"mime"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kjk/notionapi"
@@ -22,8 +25,84 @@ var (
 
 	cacheDir     = "notion_cache"
 	notionLogDir = "log"
+
+	// max number of pages we download from notion at the same time
+	notionMaxConcurrentDownloads = 8
+	// how many times we try to download a page and how long we wait
+	// before the first retry. The wait doubles after each failure
+	notionDownloadTries      = 3
+	notionDownloadRetryDelay = time.Second * 2
+)
+
+// in offline mode we never talk to notion. Pages, images and settings of
+// collection views that are not in the cache are collected so that we can
+// report all of them at once, instead of failing on the first one
+var (
+	offlineMu            sync.Mutex
+	offlineMissingPages  []string
+	offlineMissingImages []string
+	// ids of pages whose collection views settings are missing
+	offlineMissingViews []string
 )
 
+func offlineAddMissingPage(pageID string) {
+	offlineMu.Lock()
+	offlineMissingPages = append(offlineMissingPages, pageID)
+	offlineMu.Unlock()
+}
+
+func offlineAddMissingImage(uri string) {
+	offlineMu.Lock()
+	offlineMissingImages = append(offlineMissingImages, uri)
+	offlineMu.Unlock()
+}
+
+func offlineAddMissingViews(pageID string) {
+	offlineMu.Lock()
+	offlineMissingViews = append(offlineMissingViews, pageID)
+	offlineMu.Unlock()
+}
+
+// offlineResetMissing forgets what was missing in previous builds
+func offlineResetMissing() {
+	offlineMu.Lock()
+	offlineMissingPages = nil
+	offlineMissingImages = nil
+	offlineMissingViews = nil
+	offlineMu.Unlock()
+}
+
+// offlineCheckMissing returns an error listing all pages, images and
+// collection views that were needed for the build but were not in the cache
+func offlineCheckMissing() error {
+	offlineMu.Lock()
+	defer offlineMu.Unlock()
+	if len(offlineMissingPages) == 0 && len(offlineMissingImages) == 0 && len(offlineMissingViews) == 0 {
+		return nil
+	}
+	sort.Strings(offlineMissingPages)
+	sort.Strings(offlineMissingImages)
+	sort.Strings(offlineMissingViews)
+	var lines []string
+	lines = append(lines, fmt.Sprintf("%d pages, %d images and collection views of %d pages are not in %s:", len(offlineMissingPages), len(offlineMissingImages), len(offlineMissingViews), cacheDir))
+	for _, id := range offlineMissingPages {
+		lines = append(lines, "  page  "+id+" https://notion.so/"+id)
+	}
+	for _, uri := range offlineMissingImages {
+		lines = append(lines, "  image "+uri)
+	}
+	for _, id := range offlineMissingViews {
+		lines = append(lines, "  views "+id+" https://notion.so/"+id)
+	}
+	return fmt.Errorf("%s", strings.Join(lines, "\n"))
+}
+
+// pageDownloader is the part of notionapi.Client needed to download a page.
+// It exists so that crawling can be tested with a fake client
+type pageDownloader interface {
+	DownloadPage(pageID string) (*notionapi.Page, error)
+}
+
 // convert 2131b10c-ebf6-4938-a127-7089ff02dbe4 to 2131b10cebf64938a1277089ff02dbe4
 func normalizeID(s string) string {
 	return notionapi.ToNoDashID(s)
@@ -75,27 +154,17 @@ func findSubPageIDs(blocks []*notionapi.Block) []string {
 	return res
 }
 
-func loadPageFromCache(dir, pageID string) *notionapi.Page {
-	cachedPath := filepath.Join(dir, pageID+".json")
-	d, err := ioutil.ReadFile(cachedPath)
-	if err != nil {
-		return nil
-	}
-
-	var page notionapi.Page
-	err = json.Unmarshal(d, &page)
-	panicIfErr(err)
-	return &page
-}
-
-// I got "connection reset by peer" error once so retry download 3 times, with a short sleep in-between
-func downloadPageRetry(c *notionapi.Client, pageID string) (*notionapi.Page, error) {
+// I got "connection reset by peer" error once so retry download a few times,
+// backing off a bit more after each failure
+func downloadPageRetry(c pageDownloader, pageID string) (*notionapi.Page, error) {
 	var res *notionapi.Page
 	var err error
-	for i := 0; i < 3; i++ {
+	delay := notionDownloadRetryDelay
+	for i := 0; i < notionDownloadTries; i++ {
 		if i > 0 {
-			fmt.Printf("Download %s failed with '%s'\n", pageID, err)
-			time.Sleep(3 * time.Second) // not sure if it matters
+			fmt.Printf("Download %s failed with '%s', retrying in %s\n", pageID, err, delay)
+			time.Sleep(delay)
+			delay *= 2
 		}
 		res, err = c.DownloadPage(pageID)
 		if err == nil {
@@ -126,17 +195,27 @@ func findImageInDir(imgDir string, sha1 string) string {
 	return ""
 }
 
-func guessExt(fileName string, contentType string) string {
+var imageContentTypeExts = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+// matches extensions of images and files (like .pdf) attached to pages
+var reFileExt = regexp.MustCompile(`^\.[a-z0-9]{1,8}$`)
+
+func guessExt(fileName string, contentType string) (string, error) {
 	ext := strings.ToLower(filepath.Ext(fileName))
-	switch ext {
-	case ".png", ".jpg", ".jpeg":
-		return ext
+	if reFileExt.MatchString(ext) {
+		return ext, nil
 	}
-	switch contentType {
-	case "image/png":
-		return ".png"
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if ext, ok := imageContentTypeExts[mediaType]; ok {
+		return ext, nil
 	}
-	panic(fmt.Errorf("Didn't find ext for file '%s', content type '%s'\n", fileName, contentType))
+	return "", fmt.Errorf("didn't find ext for file '%s', content type '%s'", fileName, contentType)
 }
 
 func downloadImage(c *notionapi.Client, uri string) ([]byte, string, error) {
@@ -145,7 +224,10 @@ func downloadImage(c *notionapi.Client, uri string) ([]byte, string, error) {
 		fmt.Printf("\n  failed with %s\n", err)
 		return nil, "", err
 	}
-	ext := guessExt(uri, img.Header.Get("Content-Type"))
+	ext, err := guessExt(uri, img.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", err
+	}
 	return img.Data, ext, nil
 }
 
@@ -165,10 +247,19 @@ func downloadAndCacheImage(c *notionapi.Client, uri string) (string, error) {
 		return cachedPath, nil
 	}
 
+	if flgOffline {
+		// build continues so that we can report all missing images
+		offlineAddMissingImage(uri)
+		return filepath.Join(imgDir, sha), nil
+	}
+
 	timeStart := time.Now()
 	fmt.Printf("Downloading %s ... ", uri)
 
 	imgData, ext, err := downloadImage(c, uri)
+	if err != nil {
+		return "", err
+	}
 
 	cachedPath = filepath.Join(imgDir, sha+ext)
 
@@ -183,35 +274,39 @@ func downloadAndCacheImage(c *notionapi.Client, uri string) (string, error) {
 
 func downloadAndCachePage(c *notionapi.Client, pageID string) (*notionapi.Page, error) {
 	//fmt.Printf("downloading page with id %s\n", pageID)
+	// pages are downloaded concurrently so each download needs its own
+	// copy of the client to not share the Logger
+	client := *c
 	lf, _ := openLogFileForPageID(pageID)
 	if lf != nil {
-		c.Logger = lf
+		client.Logger = lf
 		defer lf.Close()
 	}
 	cachedPath := filepath.Join(cacheDir, pageID+".json")
-	page, err := downloadPageRetry(c, pageID)
+	page, err := downloadPageRetry(&client, pageID)
 	if err != nil {
 		return nil, err
 	}
-	d, err := json.MarshalIndent(page, "", "  ")
-	if err == nil {
-		err = ioutil.WriteFile(cachedPath, d, 0644)
-		panicIfErr(err)
-	} else {
+	// collection views might have changed with the page
+	removeCachedCollectionViews(pageID)
+	err = writeCachedPage(cachedPath, page)
+	if err != nil {
 		// not a fatal error, just a warning
-		fmt.Printf("json.Marshal() on pageID '%s' failed with %s\n", pageID, err)
+		fmt.Printf("writeCachedPage() on pageID '%s' failed with %s\n", pageID, err)
 	}
 	return page, nil
 }
 
-func notionToHTML(c *notionapi.Client, page *notionapi.Page, articles *Articles) ([]byte, []ImageMapping) {
+// notionToHTML returns html of the page and the generator, which knows
+// about images, headings etc. of the page
+func notionToHTML(c *notionapi.Client, page *notionapi.Page, articles *Articles) ([]byte, *HTMLGenerator) {
 	gen := NewHTMLGenerator(c, page)
 	if articles != nil {
 		gen.idToArticle = func(id string) *Article {
 			return articles.idToArticle[id]
 		}
 	}
-	return gen.Gen(), gen.images
+	return gen.Gen(), gen
 }
 
 func loadPageBlockInfo(c *notionapi.Client, pageID string) (*notionapi.Block, error) {
@@ -246,19 +341,40 @@ func loadPagesFromDisk(dir string) map[string]*notionapi.Page {
 		fmt.Printf("loadPagesFromDisk: os.ReadDir('%s') failed with '%s'\n", dir, err)
 		return cachedPagesFromDisk
 	}
+	nOlderFormat := 0
+	nCorrupt := 0
 	for _, f := range files {
 		pageID := pageIDFromFileName(f.Name())
 		if pageID == "" {
 			continue
 		}
-		page := loadPageFromCache(dir, pageID)
-		cachedPagesFromDisk[pageID] = page
+		// pages we skip are re-downloaded or, in offline mode, reported
+		// as missing
+		path := filepath.Join(dir, f.Name())
+		cp, err := readCachedPage(path)
+		if err != nil {
+			fmt.Printf("loadPagesFromDisk: '%s' is corrupt: %s\n", path, err)
+			nCorrupt++
+			continue
+		}
+		if cp.SchemaVersion < pageCacheSchemaVersion {
+			nOlderFormat++
+		}
+		if !isUsableCachedPage(cp) {
+			continue
+		}
+		cachedPagesFromDisk[pageID] = cp.page
 	}
-	fmt.Printf("loadPagesFromDisk: loaded %d cached pages from %s\n", len(cachedPagesFromDisk), dir)
+	fmt.Printf("loadPagesFromDisk: loaded %d cached pages from %s, %d in older format, %d corrupt\n", len(cachedPagesFromDisk), dir, nOlderFormat, nCorrupt)
 	return cachedPagesFromDisk
 }
 
-func loadNotionPage(c *notionapi.Client, pageID string, getFromCache bool, n int, isCachedPageNotOutdated map[string]bool, cachedPagesFromDisk map[string]*notionapi.Page) (*notionapi.Page, error) {
+func loadNotionPage(c *notionapi.Client, pageID string, getFromCache bool, n int, isCachedPageNotOutdated map[string]bool, isMissing map[string]bool, cachedPagesFromDisk map[string]*notionapi.Page) (*notionapi.Page, error) {
+	if isMissing[pageID] {
+		// a cached page links to it but it's no longer published
+		fmt.Printf("Page %4d %s: deleted or not public in notion, skipping. Run -gc-pages to remove it from cache\n", n, pageID)
+		return nil, nil
+	}
 	if isCachedPageNotOutdated[pageID] {
 		page := cachedPagesFromDisk[pageID]
 		//nTotalFromCache++
@@ -291,6 +407,12 @@ func loadNotionPage(c *notionapi.Client, pageID string, getFromCache bool, n int
 		}
 	*/
 
+	if flgOffline {
+		fmt.Printf("Page %4d %s: not in cache\n", n, pageID)
+		offlineAddMissingPage(pageID)
+		return nil, nil
+	}
+
 	page, err := downloadAndCachePage(c, pageID)
 	if err == nil {
 		fmt.Printf("Page %4d %s: downloaded. Title: %s\n", n, page.ID, page.Root.Title)
@@ -302,6 +424,10 @@ func isIDEqual(id1, id2 string) bool {
 	return notionapi.ToNoDashID(id1) == notionapi.ToNoDashID(id2)
 }
 
+// version of pages that notion doesn't return because they were deleted
+// or are no longer public
+const pageVersionMissing = -1
+
 func getVersionsForPages(c *notionapi.Client, ids []string) ([]int64, error) {
 	// c.Logger = os.Stdout
 	recVals, err := c.GetRecordValues(ids)
@@ -316,7 +442,7 @@ func getVersionsForPages(c *notionapi.Client, ids []string) ([]int64, error) {
 	for i, res := range results {
 		// res.Value might be nil when a page is not publicly visible or was deleted
 		if res.Value == nil {
-			versions = append(versions, 0)
+			versions = append(versions, pageVersionMissing)
 			continue
 		}
 		id := res.Value.ID
@@ -326,8 +452,11 @@ func getVersionsForPages(c *notionapi.Client, ids []string) ([]int64, error) {
 	return versions, nil
 }
 
-func checkIfPagesAreOutdated(c *notionapi.Client, cachedPagesFromDisk map[string]*notionapi.Page) map[string]bool {
+// checkIfPagesAreOutdated returns which cached pages are up to date and
+// which are missing in notion
+func checkIfPagesAreOutdated(c *notionapi.Client, cachedPagesFromDisk map[string]*notionapi.Page) (map[string]bool, map[string]bool) {
 	isCachedPageNotOutdated := map[string]bool{}
+	isMissing := map[string]bool{}
 	var ids []string
 	for id := range cachedPagesFromDisk {
 		ids = append(ids, id)
@@ -352,6 +481,10 @@ func checkIfPagesAreOutdated(c *notionapi.Client, cachedPagesFromDisk map[string
 	nOutdated := 0
 	for i, ver := range versions {
 		id := ids[i]
+		if ver == pageVersionMissing {
+			isMissing[id] = true
+			continue
+		}
 		page := cachedPagesFromDisk[id]
 		isOutdated := ver > page.Root.Version
 		isCachedPageNotOutdated[id] = !isOutdated
@@ -359,45 +492,122 @@ func checkIfPagesAreOutdated(c *notionapi.Client, cachedPagesFromDisk map[string
 			nOutdated++
 		}
 	}
-	fmt.Printf("checkIfPagesAreOutdated: %d pages, %d outdated\n", len(ids), nOutdated)
-	return isCachedPageNotOutdated
+	fmt.Printf("checkIfPagesAreOutdated: %d pages, %d outdated, %d missing in notion\n", len(ids), nOutdated, len(isMissing))
+	return isCachedPageNotOutdated, isMissing
 }
 
-func loadNotionPages(c *notionapi.Client, indexPageID string, idToPage map[string]*notionapi.Page, useCache bool) {
-	cachedPagesFromDisk := loadPagesFromDisk(cacheDir)
-	isCachedPageNotOutdated := checkIfPagesAreOutdated(c, cachedPagesFromDisk)
-
-	toVisit := []string{indexPageID}
+// notionCrawler loads a tree of notion pages, starting from a set of
+// root pages and following links to sub-pages. At most maxConcurrent
+// pages are loaded at the same time
+type notionCrawler struct {
+	maxConcurrent int
+	// loads a single page. n is a sequence number, only used for logging.
+	// Can return nil page for pages that should be skipped
+	loadPage func(pageID string, n int) (*notionapi.Page, error)
+}
 
-	n := 1
-	for len(toVisit) > 0 {
-		pageID := normalizeID(toVisit[0])
-		toVisit = toVisit[1:]
+type crawledPage struct {
+	pageID string
+	page   *notionapi.Page
+	err    error
+}
 
-		if _, ok := idToPage[pageID]; ok {
+// crawl loads startIDs and all pages reachable from them into idToPage.
+// Pages already in idToPage are not loaded again. Page ids are de-duplicated
+// so that a page is never loaded twice, even if it's linked from many pages.
+// On error we wait for in-flight loads to finish and return the first error
+func (cr *notionCrawler) crawl(startIDs []string, idToPage map[string]*notionapi.Page) error {
+	maxConcurrent := cr.maxConcurrent
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	// ids that were either loaded or scheduled for loading
+	seen := map[string]bool{}
+	for id := range idToPage {
+		seen[id] = true
+	}
+	var toVisit []string
+	addToVisit := func(ids []string) {
+		for _, id := range ids {
+			id = normalizeID(id)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			toVisit = append(toVisit, id)
+		}
+	}
+	addToVisit(startIDs)
+
+	results := make(chan crawledPage)
+	nInFlight := 0
+	n := 0
+	var firstErr error
+	for {
+		for firstErr == nil && len(toVisit) > 0 && nInFlight < maxConcurrent {
+			pageID := toVisit[0]
+			toVisit = toVisit[1:]
+			n++
+			nInFlight++
+			go func(pageID string, n int) {
+				page, err := cr.loadPage(pageID, n)
+				results <- crawledPage{pageID: pageID, page: page, err: err}
+			}(pageID, n)
+		}
+		if nInFlight == 0 {
+			break
+		}
+		res := <-results
+		nInFlight--
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("loading page %s failed with '%s'", res.pageID, res.err)
+			}
 			continue
 		}
+		if res.page == nil {
+			continue
+		}
+		idToPage[res.pageID] = res.page
+		addToVisit(findSubPageIDs(res.page.Root.Content))
+	}
+	return firstErr
+}
 
-		page, err := loadNotionPage(c, pageID, useCache, n, isCachedPageNotOutdated, cachedPagesFromDisk)
-		panicIfErr(err)
-		n++
-
-		idToPage[pageID] = page
+// loadNotionPages loads pages reachable from startIDs into idToPage. Returns
+// ids of cached pages that are deleted or no longer public in notion
+func loadNotionPages(c *notionapi.Client, startIDs []string, idToPage map[string]*notionapi.Page, useCache bool) map[string]bool {
+	cachedPagesFromDisk := loadPagesFromDisk(cacheDir)
+	var isCachedPageNotOutdated map[string]bool
+	isMissing := map[string]bool{}
+	if flgOffline {
+		// can't check versions without talking to notion
+		isCachedPageNotOutdated = map[string]bool{}
+		for id := range cachedPagesFromDisk {
+			isCachedPageNotOutdated[id] = true
+		}
+	} else {
+		isCachedPageNotOutdated, isMissing = checkIfPagesAreOutdated(c, cachedPagesFromDisk)
+	}
+	for _, id := range startIDs {
+		panicIf(isMissing[normalizeID(id)], "start page %s is deleted or not public in notion", id)
+	}
 
-		subPages := findSubPageIDs(page.Root.Content)
-		toVisit = append(toVisit, subPages...)
+	crawler := &notionCrawler{
+		maxConcurrent: notionMaxConcurrentDownloads,
+		loadPage: func(pageID string, n int) (*notionapi.Page, error) {
+			return loadNotionPage(c, pageID, useCache, n, isCachedPageNotOutdated, isMissing, cachedPagesFromDisk)
+		},
 	}
+	err := crawler.crawl(startIDs, idToPage)
+	panicIfErr(err)
+	return isMissing
 }
 
 func loadAllPages(c *notionapi.Client, startIDs []string, useCache bool) map[string]*notionapi.Page {
 	idToPage := map[string]*notionapi.Page{}
-	nPrev := 0
-	for _, startID := range startIDs {
-		loadNotionPages(c, startID, idToPage, useCache)
-		nDownloaded := len(idToPage) - nPrev
-		fmt.Printf("Downloaded %d pages\n", nDownloaded)
-		nPrev = len(idToPage)
-	}
+	loadNotionPages(c, startIDs, idToPage, useCache)
+	fmt.Printf("Loaded %d pages\n", len(idToPage))
 	return idToPage
 }
 
@@ -410,8 +620,12 @@ func rmFile(path string) {
 
 func rmCached(pageID string) {
 	id := normalizeID(pageID)
-	rmFile(filepath.Join(notionLogDir, id+".go.log.txt"))
+	logPath := filepath.Join(notionLogDir, id+".go.log.txt")
+	if _, err := os.Stat(logPath); err == nil {
+		rmFile(logPath)
+	}
 	rmFile(filepath.Join(cacheDir, id+".json"))
+	removeCachedCollectionViews(id)
 }
 
 func createNotionCacheDir() {
@@ -439,6 +653,56 @@ func removeCachedNotion() {
 	createNotionDirs()
 }
 
+// notionSource is a ContentSource for pages reachable from start pages in notion
+type notionSource struct {
+	client   *notionapi.Client
+	startIDs []string
+	idToPage map[string]*notionapi.Page
+	articles []*Article
+}
+
+func newNotionSource(c *notionapi.Client, startIDs ...string) *notionSource {
+	return &notionSource{
+		client:   c,
+		startIDs: startIDs,
+	}
+}
+
+// LoadArticles loads pages from notion (or cache) and extracts article metadata
+func (s *notionSource) LoadArticles() ([]*Article, error) {
+	s.idToPage = loadAllPages(s.client, s.startIDs, useCacheForNotion)
+
+	// pages are downloaded concurrently, so process them in a stable order
+	// to keep the build reproducible
+	var ids []string
+	for id := range s.idToPage {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	s.articles = nil
+	for _, id := range ids {
+		page := s.idToPage[id]
+		panicIf(id != normalizeID(id), "bad id '%s' sneaked in", id)
+		article := notionPageToArticle(s.client, page)
+		s.articles = append(s.articles, article)
+	}
+	return s.articles, nil
+}
+
+// RenderArticles converts notion pages to html
+func (s *notionSource) RenderArticles(store *Articles) error {
+	for _, article := range s.articles {
+		html, gen := notionToHTML(s.client, article.page, store)
+		article.BodyHTML = sanitizeHTML(html)
+		article.HTMLBody = template.HTML(article.BodyHTML)
+		article.Images = append(article.Images, gen.images...)
+		article.TOC = gen.headings
+		article.tocInBody = gen.hasTOCBlock
+	}
+	return nil
+}
+
 // this re-downloads pages from Notion by deleting cache locally
 func notionRedownloadAll(c *notionapi.Client) {
 	//notionapi.DebugLog = true
@@ -449,8 +713,8 @@ func notionRedownloadAll(c *notionapi.Client) {
 	createNotionDirs()
 
 	timeStart := time.Now()
-	articles := loadArticles(c)
-	fmt.Printf("Loaded %d articles in %s\n", len(articles.idToPage), time.Since(timeStart))
+	articles := loadArticles([]ContentSource{newNotionSource(c, site.NotionStartPage)})
+	fmt.Printf("Loaded %d articles in %s\n", len(articles.articles), time.Since(timeStart))
 }
 
 func notionRedownloadOne(c *notionapi.Client, id string) {
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kjk/notionapi"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNotionClient serves pages from memory and records how it was called
+type fakeNotionClient struct {
+	mu       sync.Mutex
+	pages    map[string]*notionapi.Page
+	failures map[string]int
+	calls    map[string]int
+	inFlight int
+	// max number of concurrent DownloadPage calls we've seen
+	maxInFlight int
+}
+
+func newFakeNotionClient() *fakeNotionClient {
+	return &fakeNotionClient{
+		pages:    map[string]*notionapi.Page{},
+		failures: map[string]int{},
+		calls:    map[string]int{},
+	}
+}
+
+func fakePageID(n int) string {
+	return fmt.Sprintf("%032x", n)
+}
+
+// addPage creates a page with id n that links to sub-pages with given ids
+func (c *fakeNotionClient) addPage(n int, subPages ...int) {
+	id := fakePageID(n)
+	root := &notionapi.Block{
+		ID:    id,
+		Type:  notionapi.BlockPage,
+		Title: fmt.Sprintf("page %d", n),
+	}
+	for _, sub := range subPages {
+		b := &notionapi.Block{
+			ID:   fakePageID(sub),
+			Type: notionapi.BlockPage,
+		}
+		root.Content = append(root.Content, b)
+	}
+	c.pages[id] = &notionapi.Page{
+		ID:   id,
+		Root: root,
+	}
+}
+
+func (c *fakeNotionClient) DownloadPage(pageID string) (*notionapi.Page, error) {
+	c.mu.Lock()
+	c.calls[pageID]++
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	fail := c.failures[pageID] > 0
+	if fail {
+		c.failures[pageID]--
+	}
+	page := c.pages[pageID]
+	c.mu.Unlock()
+
+	// give other downloads a chance to overlap with this one
+	time.Sleep(time.Millisecond)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+
+	if fail {
+		return nil, fmt.Errorf("connection reset by peer")
+	}
+	if page == nil {
+		return nil, fmt.Errorf("Couldn't retrieve page with id %s", pageID)
+	}
+	return page, nil
+}
+
+func newFakeCrawler(c *fakeNotionClient, maxConcurrent int) *notionCrawler {
+	return &notionCrawler{
+		maxConcurrent: maxConcurrent,
+		loadPage: func(pageID string, n int) (*notionapi.Page, error) {
+			return downloadPageRetry(c, pageID)
+		},
+	}
+}
+
+func TestNotionCrawler(t *testing.T) {
+	prevDelay := notionDownloadRetryDelay
+	defer func() {
+		notionDownloadRetryDelay = prevDelay
+	}()
+	notionDownloadRetryDelay = 0
+
+	c := newFakeNotionClient()
+	// 1 and 2 both link to 3 and 3 links back to 0
+	c.addPage(0, 1, 2)
+	c.addPage(1, 3, 4)
+	c.addPage(2, 3, 5)
+	c.addPage(3, 0, 6)
+	// 4 to 31 have no sub-pages except 6, which links to 7 to 20.
+	// That makes 0 to 20 reachable and 21 to 31 not
+	for i := 4; i < 32; i++ {
+		c.addPage(i)
+	}
+	c.addPage(6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20)
+	c.failures[fakePageID(5)] = notionDownloadTries - 1
+
+	idToPage := map[string]*notionapi.Page{}
+	err := newFakeCrawler(c, 4).crawl([]string{fakePageID(0)}, idToPage)
+	assert.NoError(t, err)
+	assert.Equal(t, 21, len(idToPage))
+	for id, page := range idToPage {
+		assert.Equal(t, c.pages[id], page)
+		if id == fakePageID(5) {
+			assert.Equal(t, notionDownloadTries, c.calls[id])
+		} else {
+			assert.Equal(t, 1, c.calls[id], "page %s downloaded more than once", id)
+		}
+	}
+	assert.True(t, c.maxInFlight <= 4, "maxInFlight is %d", c.maxInFlight)
+}
+
+func TestNotionCrawlerSkipsLoadedPages(t *testing.T) {
+	c := newFakeNotionClient()
+	c.addPage(0, 1)
+	c.addPage(1)
+
+	idToPage := map[string]*notionapi.Page{
+		fakePageID(1): c.pages[fakePageID(1)],
+	}
+	err := newFakeCrawler(c, 2).crawl([]string{fakePageID(0)}, idToPage)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(idToPage))
+	assert.Equal(t, 0, c.calls[fakePageID(1)])
+}
+
+func TestNotionCrawlerError(t *testing.T) {
+	prevDelay := notionDownloadRetryDelay
+	defer func() {
+		notionDownloadRetryDelay = prevDelay
+	}()
+	notionDownloadRetryDelay = 0
+
+	c := newFakeNotionClient()
+	c.addPage(0, 1, 2)
+	c.addPage(1)
+	c.addPage(2)
+	c.failures[fakePageID(2)] = notionDownloadTries
+
+	idToPage := map[string]*notionapi.Page{}
+	err := newFakeCrawler(c, 2).crawl([]string{fakePageID(0)}, idToPage)
+	assert.Error(t, err)
+	assert.Nil(t, idToPage[fakePageID(2)])
+}
+
+func TestNotionCrawlerSkipsNilPages(t *testing.T) {
+	c := newFakeNotionClient()
+	c.addPage(0, 1, 2)
+	c.addPage(2)
+	crawler := &notionCrawler{
+		maxConcurrent: 2,
+		loadPage: func(pageID string, n int) (*notionapi.Page, error) {
+			// mimics offline mode where pages not in cache are skipped
+			return c.pages[pageID], nil
+		},
+	}
+	idToPage := map[string]*notionapi.Page{}
+	err := crawler.crawl([]string{fakePageID(0)}, idToPage)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(idToPage))
+	_, ok := idToPage[fakePageID(1)]
+	assert.False(t, ok)
+}
@@ -5,7 +5,6 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/kjk/notionapi"
 )
@@ -57,7 +56,7 @@ func testNotionToHTMLOnePage(c *notionapi.Client, id string) {
 		LinkedInShareURL   string
 		GooglePlusShareURL string
 	}{
-		AnalyticsCode:      analyticsCode,
+		AnalyticsCode:      site.AnalyticsCode,
 		Article:            article,
 		CanonicalURL:       canonicalURL,
 		CoverImage:         article.HeaderImageURL,
@@ -84,12 +83,5 @@ func testNotionToHTMLOnePage(c *notionapi.Client, id string) {
 	panicIfErr(err)
 	copyCSS()
 
-	err = os.Chdir(destDir)
-	panicIfErr(err)
-
-	go func() {
-		time.Sleep(time.Second * 1)
-		openBrowser("http://localhost:2015")
-	}()
-	runCaddy()
+	runPreviewServer("localhost:2015", destDir)
 }
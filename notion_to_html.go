@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"html"
 	"io"
@@ -11,7 +12,6 @@ import (
 
 	"github.com/alecthomas/template"
 	"github.com/kjk/notionapi"
-	"github.com/kylelemons/godebug/pretty"
 )
 
 // ImageMapping keeps track of rewritten image urls (locally cached
@@ -21,6 +21,18 @@ type ImageMapping struct {
 	relativeURL string
 }
 
+// block types that notionapi doesn't define
+const (
+	blockCallout         = "callout"
+	blockEquation        = "equation"
+	blockAudio           = "audio"
+	blockBreadcrumb      = "breadcrumb"
+	blockTableOfContents = "table_of_contents"
+)
+
+// table of contents block is rendered after we've seen all the headings
+const tocPlaceholder = "<!-- notion-table-of-contents -->"
+
 // HTMLGenerator generates an .html file for single notion page
 type HTMLGenerator struct {
 	f            *bytes.Buffer
@@ -32,6 +44,11 @@ type HTMLGenerator struct {
 	err          error
 	idToArticle  func(string) *Article
 	images       []ImageMapping
+	slugs        *headingSlugs
+	headings     []tocHeading
+	hasTOCBlock  bool
+	// settings of collection views in the page, by view id
+	collectionViews map[string]*collectionViewSettings
 }
 
 // NewHTMLGenerator returns new HTMLGenerator
@@ -40,6 +57,7 @@ func NewHTMLGenerator(c *notionapi.Client, page *notionapi.Page) *HTMLGenerator
 		notionClient: c,
 		f:            &bytes.Buffer{},
 		page:         page,
+		slugs:        newHeadingSlugs(),
 	}
 }
 
@@ -49,13 +67,17 @@ func (g *HTMLGenerator) Gen() []byte {
 	f := page.FormatPage
 	g.writeString(`<p></p>`)
 	if f != nil && f.PageFont == "mono" {
-		g.writeString(`<div style="font-family: monospace">`)
+		g.writeString(`<div class="font-mono">`)
 	}
 	g.genContent(g.page.Root)
 	if f != nil && f.PageFont == "mono" {
 		g.writeString(`</div>`)
 	}
-	return g.f.Bytes()
+	d := g.f.Bytes()
+	if g.hasTOCBlock {
+		d = bytes.Replace(d, []byte(tocPlaceholder), []byte(tocHTML(g.headings)), -1)
+	}
+	return d
 }
 
 // only hex chars seem to be valid
@@ -147,9 +169,14 @@ func formatDate(d *notionapi.Date) string {
 	if d.DateFormat == "relative" {
 		return d.StartDate
 	}
-	fmt.Printf("formatDate: unhandled date:\n")
-	pretty.Print(d)
-	return "@TODO: date"
+	nd := &notionDate{
+		DateFormat: d.DateFormat,
+		StartDate:  d.StartDate,
+	}
+	if d.StartTime != nil {
+		nd.StartTime = *d.StartTime
+	}
+	return nd.String()
 }
 
 func (g *HTMLGenerator) genInlineBlock(b *notionapi.InlineBlock) {
@@ -173,20 +200,20 @@ func (g *HTMLGenerator) genInlineBlock(b *notionapi.InlineBlock) {
 	skipText := false
 	if b.Link != "" {
 		link := g.maybeReplaceNotionLink(b.Link)
-		start += fmt.Sprintf(`<a href="%s">%s</a>`, link, b.Text)
+		start += fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(link), html.EscapeString(b.Text))
 		skipText = true
 	}
 	if b.UserID != "" {
-		start += fmt.Sprintf(`<span class="user">@%s</span>`, b.UserID)
+		start += fmt.Sprintf(`<span class="user">@%s</span>`, html.EscapeString(b.UserID))
 		skipText = true
 	}
 	if b.Date != nil {
 		s := formatDate(b.Date)
-		start += fmt.Sprintf(`<span class="date">%s</span>`, s)
+		start += fmt.Sprintf(`<span class="date">%s</span>`, html.EscapeString(s))
 		skipText = true
 	}
 	if !skipText {
-		start += b.Text
+		start += html.EscapeString(b.Text)
 	}
 	g.writeString(start + close)
 }
@@ -212,89 +239,55 @@ func (g *HTMLGenerator) genBlockSurrouded(block *notionapi.Block, start, close s
 	g.writeString(close + "\n")
 }
 
-/*
-v is expected to be
-[
-	[
-		"foo"
-	]
-]
-and we want to return "foo"
-If not present or unexpected shape, return ""
-is still visible
-*/
-func propsValueToText(v interface{}) string {
-	if v == nil {
-		return ""
-	}
-
-	// [ [ "foo" ]]
-	a, ok := v.([]interface{})
-	if !ok {
-		return fmt.Sprintf("type1: %T", v)
-	}
-	// [ "foo" ]
-	if len(a) == 0 {
-		return ""
-	}
-	v = a[0]
-	a, ok = v.([]interface{})
-	if !ok {
-		return fmt.Sprintf("type2: %T", v)
-	}
-	// "foo"
-	if len(a) == 0 {
-		return ""
-	}
-	v = a[0]
-	str, ok := v.(string)
-	if !ok {
-		return fmt.Sprintf("type3: %T", v)
-	}
-	return str
+// genEmbedLink generates a link to content we can't embed because its
+// host is not in site.IframeHosts
+func (g *HTMLGenerator) genEmbedLink(uri string) {
+	fmt.Printf("Warning: not embedding '%s' from page https://notion.so/%s because its host is not in iframe_hosts\n", uri, normalizeID(g.page.ID))
+	uri = html.EscapeString(uri)
+	fmt.Fprintf(g.f, `<div class="embed-link%s"><a href="%s">%s</a></div>`+"\n", g.levelCls, uri, uri)
 }
 
 func (g *HTMLGenerator) genVideo(block *notionapi.Block) {
 	f := block.FormatVideo
-	s := fmt.Sprintf(`<iframe width="%d" height="%d" src="%s" frameborder="0" allow="encrypted-media" allowfullscreen></iframe>`, f.BlockWidth, f.BlockHeight, f.DisplaySource)
+	if !site.isAllowedIframeURL(f.DisplaySource) {
+		g.genEmbedLink(f.DisplaySource)
+		return
+	}
+	s := fmt.Sprintf(`<iframe width="%d" height="%d" src="%s" frameborder="0" allow="encrypted-media" allowfullscreen></iframe>`, f.BlockWidth, f.BlockHeight, html.EscapeString(f.DisplaySource))
 	g.writeString(s)
 }
 
-func (g *HTMLGenerator) genCollectionView(block *notionapi.Block) {
-	viewInfo := block.CollectionViews[0]
-	view := viewInfo.CollectionView
-	columns := view.Format.TableProperties
-	s := `<table class="notion-table"><thead><tr>`
-	for _, col := range columns {
-		colName := col.Property
-		colInfo := viewInfo.Collection.CollectionSchema[colName]
-		name := colInfo.Name
-		s += `<th>` + html.EscapeString(name) + `</th>`
-	}
-	s += `</tr></thead>`
-	s += `<tbody>`
-	for _, row := range viewInfo.CollectionRows {
-		s += `<tr>`
-		props := row.Properties
-		for _, col := range columns {
-			colName := col.Property
-			v := props[colName]
-			colVal := propsValueToText(v)
-			if colVal == "" {
-				// use &nbsp; so that empty row still shows up
-				// could also set a min-height to 1em or sth. like that
-				s += `<td>&nbsp;</td>`
-			} else {
-				//colInfo := viewInfo.Collection.CollectionSchema[colName]
-				// TODO: format colVal according to colInfo
-				s += `<td>` + html.EscapeString(colVal) + `</td>`
+// columnRatios returns widths of columns as fractions that add up to 1.
+// Columns without a ratio share what's left equally
+func columnRatios(cols []*notionapi.Block) []float64 {
+	res := make([]float64, len(cols))
+	total := 0.0
+	nMissing := 0
+	for i, col := range cols {
+		if col.FormatColumn != nil && col.FormatColumn.ColumnRation > 0 {
+			res[i] = col.FormatColumn.ColumnRation
+			total += res[i]
+		} else {
+			nMissing++
+		}
+	}
+	if nMissing > 0 {
+		missing := 1.0 / float64(len(cols))
+		if total < 1 {
+			missing = (1 - total) / float64(nMissing)
+		}
+		for i := range res {
+			if res[i] == 0 {
+				res[i] = missing
+				total += missing
 			}
 		}
-		s += `</tr>`
 	}
-	s += `</tbody>`
-	s += `</table>`
-	g.writeString(s)
+	// ratios in notion don't always add up to 1
+	for i := range res {
+		res[i] /= total
+	}
+	return res
 }
 
 // Children of BlockColumnList are BlockColumn blocks
@@ -302,14 +295,14 @@ func (g *HTMLGenerator) genColumnList(block *notionapi.Block) {
 	panicIf(block.Type != notionapi.BlockColumnList, "unexpected block type '%s'", block.Type)
 	nColumns := len(block.Content)
 	panicIf(nColumns == 0, "has no columns")
-	// TODO: for now equal width columns
 	s := `<div class="column-list">`
 	g.writeString(s)
 
-	for _, col := range block.Content {
-		// TODO: get column ration from col.FormatColumn.ColumnRation, which is float 0...1
+	ratios := columnRatios(block.Content)
+	for i, col := range block.Content {
 		panicIf(col.Type != notionapi.BlockColumn, "unexpected block type '%s'", col.Type)
-		g.writeString(`<div>`)
+		// must match reColumnStyle in sanitize.go
+		fmt.Fprintf(g.f, `<div class="column" style="flex: %.2f 1 0%%">`, ratios[i]*100)
 		g.genBlocks(col.Content)
 		g.writeString(`</div>`)
 	}
@@ -341,41 +334,26 @@ func (g *HTMLGenerator) genToggle(block *notionapi.Block) {
 	g.genBlocks(block.Content)
 	inner := g.restoreBuffer(b)
 
-	s := fmt.Sprintf(`<div style="width: 100%%; margin-top: 2px; margin-bottom: 1px;">
-    <div style="display: flex; align-items: flex-start; width: 100%%; padding-left: 2px; color: rgb(66, 66, 65);">
-
-        <div style="margin-right: 4px; width: 24px; flex-grow: 0; flex-shrink: 0; display: flex; align-items: center; justify-content: center; min-height: calc((1.5em + 3px) + 3px); padding-right: 2px;">
-            <div id="toggle-toggle-%s" onclick="javascript:onToggleClick(this)" class="toggler" style="align-items: center; user-select: none; display: flex; width: 1.25rem; height: 1.25rem; justify-content: center; flex-shrink: 0;">
-
-                <svg id="toggle-closer-%s" width="100%%" height="100%%" viewBox="0 0 100 100" style="fill: currentcolor; display: none; width: 0.6875em; height: 0.6875em; transition: transform 300ms ease-in-out; transform: rotateZ(180deg);">
-                    <polygon points="5.9,88.2 50,11.8 94.1,88.2 "></polygon>
-                </svg>
-
-                <svg id="toggle-opener-%s" width="100%%" height="100%%" viewBox="0 0 100 100" style="fill: currentcolor; display: block; width: 0.6875em; height: 0.6875em; transition: transform 300ms ease-in-out; transform: rotateZ(90deg);">
-                    <polygon points="5.9,88.2 50,11.8 94.1,88.2 "></polygon>
-                </svg>
-            </div>
-        </div>
-
-        <div style="flex: 1 1 0px; min-width: 1px;">
-            <div style="display: flex;">
-                <div style="padding-top: 3px; padding-bottom: 3px">%s</div>
-            </div>
-
-            <div style="margin-left: -2px; display: none" id="toggle-content-%s">
-                <div style="display: flex; flex-direction: column;">
-                    <div style="width: 100%%; margin-top: 2px; margin-bottom: 0px;">
-                        <div style="color: rgb(66, 66, 65);">
-							<div style="">
-								%s
-                                <!-- <div style="padding: 3px 2px;">text inside list</div> -->
-                            </div>
-                        </div>
-                    </div>
-                </div>
-            </div>
-        </div>
+	// styles are in main.css and click handlers are set in article.tmpl.html
+	s := fmt.Sprintf(`<div class="toggle">
+  <div class="toggle-row">
+    <div class="toggle-icon">
+      <div id="toggle-toggle-%s" class="toggler">
+        <svg id="toggle-closer-%s" class="toggle-closer" viewBox="0 0 100 100">
+          <polygon points="5.9,88.2 50,11.8 94.1,88.2"></polygon>
+        </svg>
+        <svg id="toggle-opener-%s" class="toggle-opener" viewBox="0 0 100 100">
+          <polygon points="5.9,88.2 50,11.8 94.1,88.2"></polygon>
+        </svg>
+      </div>
     </div>
+    <div class="toggle-body">
+      <div class="toggle-title">%s</div>
+      <div id="toggle-content-%s" class="toggle-content">
+        %s
+      </div>
+    </div>
+  </div>
 </div>
 `, id, id, id, string(inline), id, string(inner))
 	g.writeString(s)
@@ -397,13 +375,11 @@ func (g *HTMLGenerator) genBlock(block *notionapi.Block) {
 		close := `</p>`
 		g.genBlockSurrouded(block, start, close)
 	case notionapi.BlockHeader:
-		start := fmt.Sprintf(`<h1 class="hdr%s">`, g.levelCls)
-		close := `</h1>`
-		g.genBlockSurrouded(block, start, close)
+		g.genHeader(block, 1)
 	case notionapi.BlockSubHeader:
-		start := fmt.Sprintf(`<h2 class="hdr%s">`, g.levelCls)
-		close := `</h2>`
-		g.genBlockSurrouded(block, start, close)
+		g.genHeader(block, 2)
+	case notionapi.BlockSubSubHeader:
+		g.genHeader(block, 3)
 	case notionapi.BlockTodo:
 		clsChecked := ""
 		if block.IsChecked {
@@ -427,7 +403,7 @@ func (g *HTMLGenerator) genBlock(block *notionapi.Block) {
 		}
 		url, title := g.getURLAndTitleForBlock(block)
 		title = template.HTMLEscapeString(title)
-		html := fmt.Sprintf(`<div class="%s%s"><a href="%s">%s</a></div>`, cls, g.levelCls, url, title)
+		html := fmt.Sprintf(`<div class="%s%s"><a href="%s">%s</a></div>`, cls, g.levelCls, template.HTMLEscapeString(url), title)
 		fmt.Fprintf(g.f, "%s\n", html)
 	case notionapi.BlockCode:
 		/*
@@ -439,10 +415,9 @@ func (g *HTMLGenerator) genBlock(block *notionapi.Block) {
 		*/
 		htmlHighlight(g.f, string(block.Code), block.CodeLanguage, "")
 	case notionapi.BlockBookmark:
-		fmt.Fprintf(g.f, `<div class="bookmark %s">Bookmark to %s</div>`+"\n", g.levelCls, block.Link)
+		g.genBookmark(block)
 	case notionapi.BlockGist:
-		s := fmt.Sprintf(`<script src="%s.js"></script>`, block.Source)
-		g.writeString(s)
+		g.writeString(gistLink(block.Source) + "\n")
 	case notionapi.BlockImage:
 		g.genImage(block)
 	case notionapi.BlockColumnList:
@@ -452,14 +427,191 @@ func (g *HTMLGenerator) genBlock(block *notionapi.Block) {
 	case notionapi.BlockVideo:
 		g.genVideo(block)
 	case notionapi.BlockFile:
-		// TODO: add support for this type in notionapi, render as a link
-		// block id: 8c5fd467-989b-4180-902c-9b5d30c6568d
+		g.genFile(block)
+	case notionapi.BlockEmbed:
+		g.genEmbed(block)
+	case blockAudio:
+		fmt.Fprintf(g.f, `<audio class="audio%s" controls preload="none" src="%s"></audio>`+"\n", g.levelCls, html.EscapeString(block.Source))
+	case blockCallout:
+		g.genCallout(block)
+	case blockEquation:
+		g.genEquation(block)
+	case blockBreadcrumb:
+		g.genBreadcrumb()
+	case blockTableOfContents:
+		g.hasTOCBlock = true
+		g.writeString(tocPlaceholder + "\n")
 	default:
-		fmt.Printf("Unsupported block type '%s', id: %s\n", block.Type, block.ID)
-		panic(fmt.Sprintf("Unsupported block type '%s'", block.Type))
+		g.genUnsupported(block)
 	}
 }
 
+// inlineText returns text of inline blocks, without formatting
+func inlineText(blocks []*notionapi.InlineBlock) string {
+	var parts []string
+	for _, b := range blocks {
+		parts = append(parts, b.Text)
+	}
+	return strings.TrimSpace(strings.Join(parts, ""))
+}
+
+// parseBlockFormat parses format of blocks that notionapi doesn't parse
+func parseBlockFormat(block *notionapi.Block, v interface{}) {
+	if len(block.FormatRaw) == 0 {
+		return
+	}
+	err := json.Unmarshal(block.FormatRaw, v)
+	if err != nil {
+		fmt.Printf("parseBlockFormat: json.Unmarshal() of format of block %s failed with '%s'\n", block.ID, err)
+	}
+}
+
+type formatCallout struct {
+	PageIcon   string `json:"page_icon"`
+	BlockColor string `json:"block_color"`
+}
+
+func (g *HTMLGenerator) genCallout(block *notionapi.Block) {
+	var f formatCallout
+	parseBlockFormat(block, &f)
+	cls := "callout" + g.levelCls
+	if f.BlockColor != "" {
+		cls += " callout-" + urlify(f.BlockColor)
+	}
+	icon := ""
+	// icon is usually an emoji but can be an url of an image
+	if strings.HasPrefix(f.PageIcon, "http") {
+		icon = fmt.Sprintf(`<img src="%s" />`, html.EscapeString(f.PageIcon))
+	} else {
+		icon = html.EscapeString(f.PageIcon)
+	}
+	start := fmt.Sprintf(`<div class="%s"><div class="callout-icon">%s</div><div class="callout-text">`, cls, icon)
+	g.genBlockSurrouded(block, start, `</div></div>`)
+}
+
+// genEquation renders TeX of the equation to MathML (see equationHTML)
+func (g *HTMLGenerator) genEquation(block *notionapi.Block) {
+	tex := inlineText(block.InlineContent)
+	s, err := equationHTML(tex, true)
+	if err != nil {
+		fmt.Printf("Warning: can't render equation '%s' in page https://notion.so/%s. %s\n", tex, normalizeID(g.page.ID), err)
+	}
+	fmt.Fprintf(g.f, `<div class="equation%s">%s</div>`+"\n", g.levelCls, s)
+}
+
+func (g *HTMLGenerator) genEmbed(block *notionapi.Block) {
+	uri := block.Source
+	height := 480
+	if f := block.FormatEmbed; f != nil {
+		if f.DisplaySource != "" {
+			uri = f.DisplaySource
+		}
+		if f.BlockHeight > 0 {
+			height = int(f.BlockHeight)
+		}
+	}
+	if !site.isAllowedIframeURL(uri) {
+		g.genEmbedLink(uri)
+		return
+	}
+	fmt.Fprintf(g.f, `<div class="embed%s"><iframe src="%s" width="100%%" height="%d" frameborder="0" loading="lazy" allowfullscreen></iframe></div>`+"\n", g.levelCls, html.EscapeString(uri), height)
+}
+
+// genFile generates a link to a file, which we cache and host ourselves
+// the same way as images
+func (g *HTMLGenerator) genFile(block *notionapi.Block) {
+	link := block.Source
+	path, err := downloadAndCacheImage(g.notionClient, link)
+	if err != nil {
+		fmt.Printf("genFile: downloadAndCacheImage('%s') from page https://notion.so/%s failed with '%s'\n", link, normalizeID(g.page.ID), err)
+		panicIfErr(err)
+	}
+	relURL := imageURL(path)
+	im := ImageMapping{
+		path:        path,
+		relativeURL: relURL,
+	}
+	g.images = append(g.images, im)
+	name := inlineText(block.InlineContent)
+	if name == "" {
+		name = filepath.Base(link)
+	}
+	size := ""
+	if block.FileSize != "" {
+		size = fmt.Sprintf(` <span class="file-size">%s</span>`, html.EscapeString(block.FileSize))
+	}
+	fmt.Fprintf(g.f, `<div class="file%s"><a href="%s">%s</a>%s</div>`+"\n", g.levelCls, relURL, html.EscapeString(name), size)
+}
+
+type formatBookmark struct {
+	BookmarkCover string `json:"bookmark_cover"`
+}
+
+func (g *HTMLGenerator) genBookmark(block *notionapi.Block) {
+	var f formatBookmark
+	parseBlockFormat(block, &f)
+	link := html.EscapeString(block.Link)
+	title := inlineText(block.InlineContent)
+	if title == "" {
+		title = block.Link
+	}
+	s := fmt.Sprintf(`<div class="bookmark%s"><a href="%s"><div class="bookmark-text">`, g.levelCls, link)
+	s += fmt.Sprintf(`<div class="bookmark-title">%s</div>`, html.EscapeString(title))
+	if block.Description != "" {
+		s += fmt.Sprintf(`<div class="bookmark-description">%s</div>`, html.EscapeString(block.Description))
+	}
+	s += fmt.Sprintf(`<div class="bookmark-link">%s</div></div>`, link)
+	if f.BookmarkCover != "" {
+		s += fmt.Sprintf(`<img class="bookmark-cover" src="%s" />`, html.EscapeString(f.BookmarkCover))
+	}
+	s += "</a></div>\n"
+	g.writeString(s)
+}
+
+// genBreadcrumb generates links to parent pages of this page
+func (g *HTMLGenerator) genBreadcrumb() {
+	var parents []*Article
+	parentID := g.page.Root.ParentID
+	for g.idToArticle != nil && parentID != "" {
+		a := g.idToArticle(normalizeID(parentID))
+		// also protects from cycles, which shouldn't happen
+		if a == nil || a.page == nil || len(parents) > 16 {
+			break
+		}
+		parents = append([]*Article{a}, parents...)
+		parentID = a.page.Root.ParentID
+	}
+	s := fmt.Sprintf(`<div class="breadcrumb%s"><a href="/">Home</a>`, g.levelCls)
+	for _, a := range parents {
+		s += fmt.Sprintf(` / <a href="%s">%s</a>`, a.URL(), html.EscapeString(a.Title))
+	}
+	s += fmt.Sprintf(" / %s</div>\n", html.EscapeString(g.page.Root.Title))
+	g.writeString(s)
+}
+
+// genUnsupported generates a placeholder for blocks we don't know how
+// to render. It's a warning and not an error so that new block types in
+// notion don't break the build
+func (g *HTMLGenerator) genUnsupported(block *notionapi.Block) {
+	fmt.Printf("Warning: unsupported block type '%s', id: %s in page https://notion.so/%s\n", block.Type, block.ID, normalizeID(g.page.ID))
+	fmt.Fprintf(g.f, `<div class="unsupported-block%s">Unsupported block type '%s'</div>`+"\n", g.levelCls, html.EscapeString(block.Type))
+}
+
+// genHeader generates a heading with id and remembers it for table of contents
+func (g *HTMLGenerator) genHeader(block *notionapi.Block, level int) {
+	title := inlineText(block.InlineContent)
+	id := g.slugs.slug(title)
+	h := tocHeading{
+		ID:    id,
+		Title: title,
+		Level: level,
+	}
+	g.headings = append(g.headings, h)
+	start := fmt.Sprintf(`<h%d class="hdr%s" id="%s">`, level, g.levelCls, id)
+	close := fmt.Sprintf(`%s</h%d>`, headingPermalink(id), level)
+	g.genBlockSurrouded(block, start, close)
+}
+
 func (g *HTMLGenerator) genImage(block *notionapi.Block) {
 	link := block.Source
 	path, err := downloadAndCacheImage(g.notionClient, link)
@@ -467,13 +619,46 @@ func (g *HTMLGenerator) genImage(block *notionapi.Block) {
 		fmt.Printf("genImage: downloadAndCacheImage('%s') from page https://notion.so/%s failed with '%s'\n", link, normalizeID(g.page.ID), err)
 		panicIfErr(err)
 	}
-	relURL := "/img/" + filepath.Base(path)
+	relURL := imageURL(path)
 	im := ImageMapping{
 		path:        path,
 		relativeURL: relURL,
 	}
 	g.images = append(g.images, im)
-	fmt.Fprintf(g.f, `<img class="blog-img" src="%s" />`+"\n", relURL)
+
+	caption := parsePropSegments(block.Properties["caption"])
+	alt := propSegmentsText(caption)
+	s := fmt.Sprintf(`<img class="blog-img" src="%s" alt="%s"`, relURL, html.EscapeString(alt))
+	info, err := readImageInfo(path)
+	if err != nil {
+		// images missing in offline mode are reported elsewhere
+		if !flgOffline {
+			fmt.Printf("genImage: readImageInfo('%s') from page https://notion.so/%s failed with '%s'\n", path, normalizeID(g.page.ID), err)
+		}
+	} else {
+		// width set by resizing the image in notion
+		maxWidth := 0
+		if block.FormatImage != nil {
+			maxWidth = int(block.FormatImage.BlockWidth)
+		}
+		w, h := imageDisplaySize(info, maxWidth)
+		s += fmt.Sprintf(` width="%d" height="%d"`, w, h)
+		variants := imageVariants(path, relURL, info)
+		for _, v := range variants {
+			g.images = append(g.images, ImageMapping{path: v.path, relativeURL: v.relativeURL})
+		}
+		if srcSet := imageSrcSet(relURL, info, variants); srcSet != "" {
+			if w > imageMaxDisplayWidth {
+				w = imageMaxDisplayWidth
+			}
+			s += fmt.Sprintf(` srcset="%s" sizes="(max-width: %dpx) 100vw, %dpx"`, srcSet, w, w)
+		}
+	}
+	s += ` loading="lazy">`
+	if len(caption) > 0 {
+		s = `<figure class="blog-figure">` + s + `<figcaption>` + g.propSegmentsHTML(caption) + `</figcaption></figure>`
+	}
+	g.writeString(s + "\n")
 }
 
 func (g *HTMLGenerator) genBlocks(blocks []*notionapi.Block) {
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/kjk/notionapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func textBlock(typ string, text string) *notionapi.Block {
+	return &notionapi.Block{
+		ID:   "b-" + typ,
+		Type: typ,
+		InlineContent: []*notionapi.InlineBlock{
+			{Text: text},
+		},
+	}
+}
+
+func TestNotionBlocksToHTML(t *testing.T) {
+	callout := textBlock(blockCallout, "Note")
+	callout.FormatRaw = json.RawMessage(`{"page_icon":"💡","block_color":"yellow_background"}`)
+	bookmark := textBlock(notionapi.BlockBookmark, "Go <blog>")
+	bookmark.Link = "https://blog.golang.org"
+	bookmark.Description = "The Go blog"
+	root := &notionapi.Block{
+		ID:    fakePageID(1),
+		Type:  notionapi.BlockPage,
+		Title: "Page",
+		Content: []*notionapi.Block{
+			{ID: "toc", Type: blockTableOfContents},
+			textBlock(notionapi.BlockHeader, "Intro"),
+			callout,
+			textBlock(blockEquation, "a < b"),
+			bookmark,
+			textBlock(notionapi.BlockSubSubHeader, "Intro"),
+			{ID: "x", Type: "some_new_block"},
+		},
+	}
+	page := &notionapi.Page{
+		ID:   fakePageID(1),
+		Root: root,
+	}
+	html, gen := notionToHTML(nil, page, nil)
+	s := string(html)
+	exp := []string{
+		`<div class="toc"><ul><li class="toc-lvl1"><a href="#intro">Intro</a></li><li class="toc-lvl3"><a href="#intro-1">Intro</a></li></ul></div>`,
+		`<h1 class="hdr" id="intro">`,
+		`<div class="callout callout-yellow_background"><div class="callout-icon">💡</div><div class="callout-text">`,
+		`<div class="equation"><span class="katex-display"><span class="katex"><math xmlns="http://www.w3.org/1998/Math/MathML" display="block"><semantics><mrow><mi>a</mi><mo>&lt;</mo><mi>b</mi></mrow><annotation encoding="application/x-tex">a &lt; b</annotation></semantics></math></span></span></div>`,
+		`<div class="bookmark"><a href="https://blog.golang.org"><div class="bookmark-text"><div class="bookmark-title">Go &lt;blog&gt;</div><div class="bookmark-description">The Go blog</div>`,
+		`<h3 class="hdr" id="intro-1">`,
+		`<div class="unsupported-block">Unsupported block type 'some_new_block'</div>`,
+	}
+	for _, e := range exp {
+		assert.True(t, strings.Contains(s, e), "missing '%s' in:\n%s", e, s)
+	}
+	assert.False(t, strings.Contains(s, tocPlaceholder))
+	assert.False(t, strings.Contains(s, "<blog>"))
+	assert.True(t, gen.hasTOCBlock)
+}
+
+func TestNotionInlineEscaping(t *testing.T) {
+	setTestSiteConfig()
+	page := &notionapi.Page{
+		ID: fakePageID(1),
+		Root: &notionapi.Block{
+			ID:   fakePageID(1),
+			Type: notionapi.BlockPage,
+		},
+	}
+	gen := NewHTMLGenerator(nil, page)
+	blocks := []*notionapi.InlineBlock{
+		{Text: "a <b>"},
+		{Text: "<img src=x>", Link: `https://x.com/?a=1&b="2"`},
+		{Text: "code", AttrFlags: notionapi.AttrCode},
+	}
+	s := string(gen.getInline(blocks))
+	assert.Equal(t, `a &lt;b&gt;<a href="https://x.com/?a=1&amp;b=&#34;2&#34;">&lt;img src=x&gt;</a><code>code</code>`, s)
+}
+
+func column(ratio float64, content ...*notionapi.Block) *notionapi.Block {
+	col := &notionapi.Block{
+		ID:      "col",
+		Type:    notionapi.BlockColumn,
+		Content: content,
+	}
+	if ratio > 0 {
+		col.FormatColumn = &notionapi.FormatColumn{ColumnRation: ratio}
+	}
+	return col
+}
+
+func TestColumnRatios(t *testing.T) {
+	tests := []struct {
+		ratios []float64
+		exp    []float64
+	}{
+		{[]float64{0, 0}, []float64{0.5, 0.5}},
+		{[]float64{0.25, 0.75}, []float64{0.25, 0.75}},
+		{[]float64{0.5, 0, 0}, []float64{0.5, 0.25, 0.25}},
+		{[]float64{0.5, 1}, []float64{1.0 / 3, 2.0 / 3}},
+		{[]float64{1, 0}, []float64{2.0 / 3, 1.0 / 3}},
+	}
+	for _, test := range tests {
+		var cols []*notionapi.Block
+		for _, r := range test.ratios {
+			cols = append(cols, column(r))
+		}
+		got := columnRatios(cols)
+		assert.InDeltaSlice(t, test.exp, got, 0.0001, "ratios: %v", test.ratios)
+	}
+
+	nested := &notionapi.Block{
+		ID:   "nested",
+		Type: notionapi.BlockColumnList,
+		Content: []*notionapi.Block{
+			column(0.3, textBlock(notionapi.BlockText, "a")),
+			column(0.7, textBlock(notionapi.BlockText, "b")),
+		},
+	}
+	page := &notionapi.Page{
+		ID: fakePageID(1),
+		Root: &notionapi.Block{
+			ID:   fakePageID(1),
+			Type: notionapi.BlockPage,
+			Content: []*notionapi.Block{
+				{
+					ID:      "list",
+					Type:    notionapi.BlockColumnList,
+					Content: []*notionapi.Block{column(0.6, nested), column(0.4)},
+				},
+			},
+		},
+	}
+	html, _ := notionToHTML(nil, page, nil)
+	s := sanitizeHTML(html)
+	exp := []string{
+		`<div class="column-list"><div class="column" style="flex: 60.00 1 0%"><div class="column-list"><div class="column" style="flex: 30.00 1 0%">`,
+		`<div class="column" style="flex: 70.00 1 0%">`,
+		`<div class="column" style="flex: 40.00 1 0%"></div></div>`,
+	}
+	for _, e := range exp {
+		assert.True(t, strings.Contains(s, e), "missing '%s' in:\n%s", e, s)
+	}
+}
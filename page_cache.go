@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/kjk/notionapi"
+)
+
+// Pages are cached in notion_cache/${pageID}.json in an envelope that
+// records when and how the page was downloaded. Pages cached before we had
+// the envelope are plain notionapi.Page json, which we treat as schema
+// version 0.
+
+// pageCacheSchemaVersion must be bumped when format of cached pages changes.
+// Pages cached with older versions are re-downloaded, except in offline
+// mode, when we use them as they are
+const pageCacheSchemaVersion = 1
+
+type cachedPage struct {
+	SchemaVersion int `json:"schema_version"`
+	// version of notionapi that downloaded the page
+	NotionapiVersion string    `json:"notionapi_version"`
+	FetchedAt        time.Time `json:"fetched_at"`
+	PageVersion      int64     `json:"page_version"`
+	// sha1 of compacted json of the page, to detect corrupted files
+	ContentHash string          `json:"content_hash"`
+	Page        json.RawMessage `json:"page"`
+
+	page *notionapi.Page
+}
+
+func notionapiVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/kjk/notionapi" {
+				return dep.Version
+			}
+		}
+	}
+	return "unknown"
+}
+
+func pageContentHash(d []byte) (string, error) {
+	var buf bytes.Buffer
+	err := json.Compact(&buf, d)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha1.Sum(buf.Bytes())), nil
+}
+
+// marshalCachedPage returns page wrapped in cache envelope
+func marshalCachedPage(page *notionapi.Page, fetchedAt time.Time) ([]byte, error) {
+	d, err := json.Marshal(page)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := pageContentHash(d)
+	if err != nil {
+		return nil, err
+	}
+	cp := &cachedPage{
+		SchemaVersion:    pageCacheSchemaVersion,
+		NotionapiVersion: notionapiVersion(),
+		FetchedAt:        fetchedAt.UTC(),
+		PageVersion:      page.Root.Version,
+		ContentHash:      hash,
+		Page:             d,
+	}
+	return json.MarshalIndent(cp, "", "  ")
+}
+
+// unmarshalCachedPage parses cached page in current or older format
+func unmarshalCachedPage(d []byte) (*cachedPage, error) {
+	var cp cachedPage
+	err := json.Unmarshal(d, &cp)
+	if err != nil {
+		return nil, err
+	}
+	if cp.SchemaVersion == 0 {
+		// cached before we had the envelope
+		cp.Page = d
+	} else {
+		hash, err := pageContentHash(cp.Page)
+		if err != nil {
+			return nil, err
+		}
+		if hash != cp.ContentHash {
+			return nil, fmt.Errorf("content hash mismatch, expected %s, got %s", cp.ContentHash, hash)
+		}
+	}
+	var page notionapi.Page
+	err = json.Unmarshal(cp.Page, &page)
+	if err != nil {
+		return nil, err
+	}
+	if page.Root == nil {
+		return nil, fmt.Errorf("page has no root block")
+	}
+	cp.page = &page
+	return &cp, nil
+}
+
+func readCachedPage(path string) (*cachedPage, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalCachedPage(d)
+}
+
+func writeCachedPage(path string, page *notionapi.Page) error {
+	d, err := marshalCachedPage(page, time.Now())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, d)
+}
+
+// isUsableCachedPage returns false if cached page should be re-downloaded
+func isUsableCachedPage(cp *cachedPage) bool {
+	return flgOffline || cp.SchemaVersion >= pageCacheSchemaVersion
+}
+
+// loadPageFromCache returns a cached page or nil if it's not cached or must
+// be re-downloaded because it's corrupt or cached in older format
+func loadPageFromCache(dir, pageID string) *notionapi.Page {
+	cachedPath := filepath.Join(dir, pageID+".json")
+	cp, err := readCachedPage(cachedPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("loadPageFromCache: '%s' is corrupt: %s\n", cachedPath, err)
+		}
+		return nil
+	}
+	if !isUsableCachedPage(cp) {
+		logVerbose("loadPageFromCache: '%s' is cached in older format %d\n", cachedPath, cp.SchemaVersion)
+		return nil
+	}
+	return cp.page
+}
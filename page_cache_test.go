@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kjk/notionapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCachePage(id string) *notionapi.Page {
+	return &notionapi.Page{
+		ID: id,
+		Root: &notionapi.Block{
+			ID:      id,
+			Type:    notionapi.BlockPage,
+			Title:   "Cached page",
+			Version: 12,
+		},
+	}
+}
+
+func TestCachedPageRoundTrip(t *testing.T) {
+	page := testCachePage(fakePageID(1))
+	fetchedAt := time.Date(2019, 3, 26, 10, 0, 0, 0, time.UTC)
+	d, err := marshalCachedPage(page, fetchedAt)
+	assert.NoError(t, err)
+	cp, err := unmarshalCachedPage(d)
+	assert.NoError(t, err)
+	assert.Equal(t, pageCacheSchemaVersion, cp.SchemaVersion)
+	assert.Equal(t, int64(12), cp.PageVersion)
+	assert.Equal(t, fetchedAt, cp.FetchedAt)
+	assert.Equal(t, "Cached page", cp.page.Root.Title)
+
+	// content doesn't match the hash
+	corrupted := strings.Replace(string(d), "Cached page", "Changed page", 1)
+	_, err = unmarshalCachedPage([]byte(corrupted))
+	assert.Error(t, err)
+
+	// truncated write
+	_, err = unmarshalCachedPage(d[:len(d)/2])
+	assert.Error(t, err)
+
+	// pages cached before we had the envelope
+	legacy, err := json.MarshalIndent(page, "", "  ")
+	assert.NoError(t, err)
+	cp, err = unmarshalCachedPage(legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cp.SchemaVersion)
+	assert.Equal(t, "Cached page", cp.page.Root.Title)
+
+	_, err = unmarshalCachedPage([]byte(`{"ID": "x"}`))
+	assert.Error(t, err)
+}
+
+func TestLoadPageFromCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "page_cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	prevOffline := flgOffline
+	defer func() {
+		flgOffline = prevOffline
+	}()
+
+	current := fakePageID(1)
+	err = writeCachedPage(filepath.Join(dir, current+".json"), testCachePage(current))
+	assert.NoError(t, err)
+	legacy := fakePageID(2)
+	d, err := json.Marshal(testCachePage(legacy))
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, legacy+".json"), d, 0644)
+	assert.NoError(t, err)
+	corrupt := fakePageID(3)
+	err = ioutil.WriteFile(filepath.Join(dir, corrupt+".json"), d[:10], 0644)
+	assert.NoError(t, err)
+
+	flgOffline = false
+	assert.NotNil(t, loadPageFromCache(dir, current))
+	// older format is re-downloaded
+	assert.Nil(t, loadPageFromCache(dir, legacy))
+	assert.Nil(t, loadPageFromCache(dir, corrupt))
+	assert.Nil(t, loadPageFromCache(dir, fakePageID(4)))
+	assert.Equal(t, 1, len(loadPagesFromDisk(dir)))
+
+	// but used as is in offline mode
+	flgOffline = true
+	assert.NotNil(t, loadPageFromCache(dir, legacy))
+	assert.Nil(t, loadPageFromCache(dir, corrupt))
+	assert.Equal(t, 2, len(loadPagesFromDisk(dir)))
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(files), "temporary files should be renamed")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kjk/notionapi"
+)
+
+// Pages removed or unshared in notion stay in notion_cache forever.
+// -gc-pages crawls pages from the start pages and reports cached pages that
+// are no longer reachable or that notion no longer returns. With
+// -gc-pages-delete they're deleted, together with their logs in log/
+
+type pageCacheReport struct {
+	nCached int
+	// cached pages not reachable from start pages
+	unreachable []string
+	// cached pages deleted or no longer public in notion
+	missing []string
+	// logs of pages that are not cached
+	orphanedLogs []string
+	// page id => title, for reporting
+	titles map[string]string
+}
+
+// cachedPageIDs returns ids of pages in notion cache
+func cachedPageIDs(dir string) []string {
+	var res []string
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		fmt.Printf("cachedPageIDs: os.ReadDir('%s') failed with '%s'\n", dir, err)
+		return nil
+	}
+	for _, f := range files {
+		if id := pageIDFromFileName(f.Name()); id != "" {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// pageIDsWithLogs returns ids of pages that have logs of notion requests
+func pageIDsWithLogs(dir string) []string {
+	var res []string
+	files, _ := ioutil.ReadDir(dir)
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), ".go.log.txt")
+		if id != f.Name() && isValidNotionID(id) {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// diffPageCache compares cached pages with pages reached by crawling
+func diffPageCache(cachedIDs []string, logIDs []string, reachable map[string]*notionapi.Page, isMissing map[string]bool) *pageCacheReport {
+	res := &pageCacheReport{
+		nCached: len(cachedIDs),
+		titles:  map[string]string{},
+	}
+	isCached := map[string]bool{}
+	for _, id := range cachedIDs {
+		isCached[id] = true
+		switch {
+		case isMissing[id]:
+			res.missing = append(res.missing, id)
+		case reachable[id] == nil:
+			res.unreachable = append(res.unreachable, id)
+		}
+	}
+	for _, id := range logIDs {
+		if !isCached[id] && reachable[id] == nil {
+			res.orphanedLogs = append(res.orphanedLogs, id)
+		}
+	}
+	sort.Strings(res.missing)
+	sort.Strings(res.unreachable)
+	sort.Strings(res.orphanedLogs)
+	return res
+}
+
+// orphanedPages returns ids of pages that should be removed from cache
+func (r *pageCacheReport) orphanedPages() []string {
+	return append(append([]string{}, r.unreachable...), r.missing...)
+}
+
+func (r *pageCacheReport) pageDesc(id string) string {
+	if title := r.titles[id]; title != "" {
+		return id + " " + title
+	}
+	return id
+}
+
+func (r *pageCacheReport) String() string {
+	var lines []string
+	for _, id := range r.unreachable {
+		lines = append(lines, "unreachable: "+r.pageDesc(id))
+	}
+	for _, id := range r.missing {
+		lines = append(lines, "deleted or not public: "+r.pageDesc(id))
+	}
+	s := fmt.Sprintf("Checked %d cached pages: %d unreachable, %d deleted or not public, %d logs of pages not in cache", r.nCached, len(r.unreachable), len(r.missing), len(r.orphanedLogs))
+	lines = append(lines, s)
+	return strings.Join(lines, "\n")
+}
+
+func prunePageCache(r *pageCacheReport) {
+	ids := r.orphanedPages()
+	for _, id := range ids {
+		rmCached(id)
+	}
+	for _, id := range r.orphanedLogs {
+		rmFile(filepath.Join(notionLogDir, id+".go.log.txt"))
+	}
+	fmt.Printf("Deleted %d pages and %d logs\n", len(ids), len(r.orphanedLogs))
+}
+
+// gcPages reports cached pages that are not reachable from start pages or
+// are no longer published and, if deleteOrphans is true, deletes them
+func gcPages(c *notionapi.Client, startIDs []string, deleteOrphans bool) bool {
+	idToPage := map[string]*notionapi.Page{}
+	isMissing := loadNotionPages(c, startIDs, idToPage, true)
+	if flgOffline {
+		fmt.Printf("Can't check if pages were deleted in notion with -offline, only checking if they're reachable\n")
+		if err := offlineCheckMissing(); err != nil {
+			// pages reachable from missing pages would be reported as unreachable
+			fmt.Printf("%s\n", err)
+			return false
+		}
+	}
+	report := diffPageCache(cachedPageIDs(cacheDir), pageIDsWithLogs(notionLogDir), idToPage, isMissing)
+	for _, id := range report.orphanedPages() {
+		if page := loadPageFromCache(cacheDir, id); page != nil && page.Root != nil {
+			report.titles[id] = page.Root.Title
+		}
+	}
+	fmt.Printf("%s\n", report)
+	if deleteOrphans {
+		prunePageCache(report)
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kjk/notionapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffPageCache(t *testing.T) {
+	cached := []string{fakePageID(1), fakePageID(2), fakePageID(3), fakePageID(4)}
+	logs := []string{fakePageID(2), fakePageID(5), fakePageID(6)}
+	reachable := map[string]*notionapi.Page{
+		fakePageID(1): {},
+		fakePageID(4): {},
+		fakePageID(6): {},
+	}
+	isMissing := map[string]bool{
+		fakePageID(3): true,
+	}
+	r := diffPageCache(cached, logs, reachable, isMissing)
+	assert.Equal(t, 4, r.nCached)
+	assert.Equal(t, []string{fakePageID(2)}, r.unreachable)
+	assert.Equal(t, []string{fakePageID(3)}, r.missing)
+	assert.Equal(t, []string{fakePageID(5)}, r.orphanedLogs)
+	assert.Equal(t, []string{fakePageID(2), fakePageID(3)}, r.orphanedPages())
+}
+
+func TestPageIDsWithLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "page_gc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{fakePageID(1) + ".go.log.txt", "notes.txt", fakePageID(2) + ".json"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{fakePageID(1)}, pageIDsWithLogs(dir))
+	assert.Equal(t, []string{fakePageID(2)}, cachedPageIDs(dir))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// previewServer serves a site built for Netlify, interpreting _redirects
+// the same way Netlify does (see netlifyResolver). When the site is
+// re-built, it tells open browsers to reload the page
+
+var (
+	previewAddr = "localhost:8080"
+)
+
+const liveReloadURL = "/__livereload"
+
+// injected in html pages. Reloads the page when server sends a message
+const liveReloadScript = `<script>
+(function() {
+	var es = new EventSource("` + liveReloadURL + `");
+	es.onmessage = function() { location.reload(); };
+})();
+</script>
+`
+
+// liveReload keeps track of open browser pages and notifies
+// them using server-sent events
+type liveReload struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+func (lr *liveReload) add() chan struct{} {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	if lr.clients == nil {
+		lr.clients = map[chan struct{}]struct{}{}
+	}
+	ch := make(chan struct{}, 1)
+	lr.clients[ch] = struct{}{}
+	return ch
+}
+
+func (lr *liveReload) remove(ch chan struct{}) {
+	lr.mu.Lock()
+	delete(lr.clients, ch)
+	lr.mu.Unlock()
+}
+
+// notify tells all open pages to reload
+func (lr *liveReload) notify() {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for ch := range lr.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// already has a pending notification
+		}
+	}
+}
+
+func (lr *liveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	ch := lr.add()
+	defer lr.remove(ch)
+	for {
+		select {
+		case <-ch:
+			fmt.Fprintf(w, "data: reload\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+type previewServer struct {
+	dir    string
+	reload liveReload
+
+	mu       sync.Mutex
+	resolver *netlifyResolver
+}
+
+func newPreviewServer(dir string) (*previewServer, error) {
+	s := &previewServer{
+		dir: dir,
+	}
+	err := s.loadRedirects()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *previewServer) loadRedirects() error {
+	resolver, err := newNetlifyResolverFromDir(s.dir)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.resolver = resolver
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *previewServer) getResolver() *netlifyResolver {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resolver
+}
+
+// rebuilt re-reads _redirects and reloads open pages
+func (s *previewServer) rebuilt() {
+	err := s.loadRedirects()
+	if err != nil {
+		fmt.Printf("preview: failed to load _redirects with '%s'\n", err)
+	}
+	s.reload.notify()
+}
+
+// watchForRebuilds checks if a file changed and calls rebuilt() if it did.
+// We use build manifest because it's written at the end of every build
+func (s *previewServer) watchForRebuilds(path string) {
+	var lastModTime time.Time
+	if st, err := os.Stat(path); err == nil {
+		lastModTime = st.ModTime()
+	}
+	for {
+		time.Sleep(time.Second)
+		st, err := os.Stat(path)
+		if err != nil || st.ModTime().Equal(lastModTime) {
+			continue
+		}
+		lastModTime = st.ModTime()
+		fmt.Printf("preview: site was re-built, reloading\n")
+		s.rebuilt()
+	}
+}
+
+func (s *previewServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == liveReloadURL {
+		s.reload.ServeHTTP(w, r)
+		return
+	}
+	res := s.getResolver().resolve(r.URL.Path)
+	fmt.Printf("%s %d %s%s\n", r.Method, res.code, r.URL.Path, res.location)
+	if res.location != "" && res.code != 502 {
+		http.Redirect(w, r, escapeRedirectURL(res.location), res.code)
+		return
+	}
+	if res.path == "" {
+		msg := http.StatusText(res.code)
+		if res.code == 502 {
+			msg = "preview doesn't proxy to " + res.location
+		}
+		http.Error(w, msg, res.code)
+		return
+	}
+	d, err := ioutil.ReadFile(res.path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ct := mime.TypeByExtension(filepath.Ext(res.path))
+	if ct == "" {
+		ct = http.DetectContentType(d)
+	}
+	if bytes.HasPrefix([]byte(ct), []byte("text/html")) {
+		d = injectLiveReload(d)
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(res.code)
+	w.Write(d)
+}
+
+func injectLiveReload(d []byte) []byte {
+	script := []byte(liveReloadScript)
+	idx := bytes.LastIndex(d, []byte("</body>"))
+	if idx == -1 {
+		return append(d, script...)
+	}
+	res := append([]byte{}, d[:idx]...)
+	res = append(res, script...)
+	return append(res, d[idx:]...)
+}
+
+// runPreviewServer serves dir at addr, opens the browser and never returns
+func runPreviewServer(addr string, dir string) {
+	server, err := newPreviewServer(dir)
+	panicIfErr(err)
+	go server.watchForRebuilds(buildManifestPath)
+	go func() {
+		time.Sleep(time.Second * 1)
+		openBrowser("http://" + addr)
+	}()
+	fmt.Printf("Serving %s on http://%s\n", dir, addr)
+	err = http.ListenAndServe(addr, server)
+	panicIfErr(err)
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Netlify serves the site with _redirects. To serve mirrors of the site
+// with other servers we also write the same redirects as Caddy v2, nginx
+// and Apache config to server_config. They behave like Netlify: existing
+// files are served without redirects (with .html and /index.html being
+// optional), trailing slash is ignored and the first matching rule wins.
+// 404 and 410 serve a page with that status code, like a 200 rewrite.
+// Rules have unescaped paths, the way they're matched, and we percent-encode
+// them when writing configs e.g. /tag/objective c as /tag/objective%20c.
+
+const serverConfigDir = "server_config"
+
+// RedirectEmitter writes redirects in the config format of a web server
+type RedirectEmitter interface {
+	// FileName is the name of the config file
+	FileName() string
+	Emit(redirects []*netlifyRedirect) ([]byte, error)
+}
+
+// redirectEmitters write configs for mirrors of the site
+var redirectEmitters = []RedirectEmitter{
+	caddyfileEmitter{},
+	caddyJSONEmitter{},
+	nginxEmitter{},
+	apacheEmitter{},
+}
+
+func writeRedirects() {
+	redirects := allNetlifyRedirects()
+	d, err := netlifyEmitter{}.Emit(redirects)
+	panicIfErr(err)
+	netlifyWriteFile("_redirects", d)
+
+	err = os.MkdirAll(serverConfigDir, 0755)
+	panicIfErr(err)
+	for _, e := range redirectEmitters {
+		d, err := e.Emit(redirects)
+		panicIfErr(err)
+		path := filepath.Join(serverConfigDir, e.FileName())
+		err = ioutil.WriteFile(path, d, 0644)
+		panicIfErr(err)
+	}
+}
+
+// escapeRedirectURL percent-encodes characters that can't be in a url.
+// :placeholders and * are kept. Urls of other sites are already encoded
+// so we keep their %, ? and #
+func escapeRedirectURL(s string) string {
+	safe := "-._~!$&'()*+,;=:@/"
+	if isExternalURL(s) {
+		safe += "%?#"
+	}
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isASCIILetter(c) || isDigit(c) || strings.IndexByte(safe, c) != -1 {
+			buf.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&buf, "%%%02X", c)
+	}
+	return buf.String()
+}
+
+// serverRegexpEscape writes ascii characters that escapeRedirectURL
+// would encode (except regexp syntax) as \xHH. Servers match unescaped
+// paths so they can't be percent-encoded in the regexp
+func serverRegexpEscape(re string) string {
+	var buf strings.Builder
+	for i := 0; i < len(re); i++ {
+		c := re[i]
+		isSyntax := strings.IndexByte(`\^$.|?*+()[]{}`, c) != -1
+		if c < 0x80 && !isSyntax && escapeRedirectURL(string(c)) != string(c) {
+			fmt.Fprintf(&buf, `\x%02X`, c)
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
+
+// serverRule is a redirect translated to a regexp, for servers that
+// don't understand Netlify's :placeholders
+type serverRule struct {
+	*netlifyRedirect
+	// matches url path, with optional trailing slash
+	re string
+	// to with placeholders replaced by references to groups in re
+	to string
+}
+
+// newServerRule translates r. escapeTo escapes characters that are special
+// in destination urls of the server, group returns reference to n-th group
+func newServerRule(r *netlifyRedirect, escapeTo func(string) string, group func(n int) string) (*serverRule, error) {
+	switch r.code {
+	case 200, 301, 302, 404, 410:
+		// supported
+	default:
+		return nil, fmt.Errorf("%s: unsupported status code %d", r.from, r.code)
+	}
+	if r.force {
+		// we only redirect urls for which there is no file
+		return nil, fmt.Errorf("%s: forced rules are not supported", r.from)
+	}
+	re, names := netlifyRulePattern(r.from)
+	if re != "^/$" && !strings.HasSuffix(r.from, "*") {
+		re = strings.TrimSuffix(re, "$") + "/?$"
+	}
+	vals := map[string]string{}
+	for i, name := range names {
+		vals[name] = group(i + 1)
+	}
+	res := &serverRule{
+		netlifyRedirect: r,
+		re:              serverRegexpEscape(re),
+		to:              expandNetlifyPlaceholders(escapeTo(escapeRedirectURL(r.to)), vals),
+	}
+	return res, nil
+}
+
+func (r *serverRule) isRedirect() bool {
+	return r.code == 301 || r.code == 302
+}
+
+// errorPages returns pages served for 404 and 410 for servers that can
+// only have one page per status code. Like on Netlify, /404.html is
+// served for urls that don't exist
+func errorPages(redirects []*netlifyRedirect) (map[int]string, error) {
+	res := map[int]string{
+		404: "/404.html",
+	}
+	isSet := map[int]bool{}
+	for _, r := range redirects {
+		if r.code != 404 && r.code != 410 {
+			continue
+		}
+		if isSet[r.code] && res[r.code] != r.to {
+			return nil, fmt.Errorf("%s: can only serve one page for %d, already serving %s", r.from, r.code, res[r.code])
+		}
+		res[r.code] = r.to
+		isSet[r.code] = true
+	}
+	return res, nil
+}
+
+// netlifyEmitter writes Netlify's _redirects
+type netlifyEmitter struct{}
+
+func (netlifyEmitter) FileName() string {
+	return "_redirects"
+}
+
+func (netlifyEmitter) Emit(redirects []*netlifyRedirect) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, r := range redirects {
+		force := ""
+		if r.force {
+			force = "!"
+		}
+		fmt.Fprintf(&buf, "%s\t%s\t%d%s\n", escapeRedirectURL(r.from), escapeRedirectURL(r.to), r.code, force)
+	}
+	return buf.Bytes(), nil
+}
+
+// https://caddyserver.com/docs/caddyfile
+type caddyfileEmitter struct{}
+
+func (caddyfileEmitter) FileName() string {
+	return "Caddyfile"
+}
+
+// caddyEscapeTo escapes { and } so that they're not treated as placeholders
+func caddyEscapeTo(s string) string {
+	s = strings.Replace(s, "{", `\{`, -1)
+	return strings.Replace(s, "}", `\}`, -1)
+}
+
+func caddyQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+func caddyGroup(name string) func(int) string {
+	return func(n int) string {
+		return fmt.Sprintf("{http.regexp.%s.%d}", name, n)
+	}
+}
+
+const caddyfileProlog = `# generated by the blog build, don't edit
+# run from the top directory: caddy run --config server_config/Caddyfile
+:8080 {
+	root * netlify_static
+	route {
+`
+
+const caddyfileEpilog = `		file_server
+	}
+	handle_errors {
+		rewrite * /404.html
+		file_server
+	}
+}
+`
+
+func (caddyfileEmitter) Emit(redirects []*netlifyRedirect) ([]byte, error) {
+	buf := bytes.NewBufferString(caddyfileProlog)
+	for i, r := range redirects {
+		name := fmt.Sprintf("r%d", i+1)
+		rule, err := newServerRule(r, caddyEscapeTo, caddyGroup(name))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(buf, "\t\t@%s {\n", name)
+		buf.WriteString("\t\t\tnot file {path} {path}/index.html {path}.html\n")
+		fmt.Fprintf(buf, "\t\t\tpath_regexp %s %s\n", name, caddyQuote(rule.re))
+		buf.WriteString("\t\t}\n")
+		if rule.isRedirect() {
+			fmt.Fprintf(buf, "\t\tredir @%s %s %d\n", name, caddyQuote(rule.to), r.code)
+			continue
+		}
+		fmt.Fprintf(buf, "\t\thandle @%s {\n", name)
+		fmt.Fprintf(buf, "\t\t\trewrite * %s\n", caddyQuote(rule.to))
+		if r.code == 200 {
+			buf.WriteString("\t\t\tfile_server\n")
+		} else {
+			fmt.Fprintf(buf, "\t\t\tfile_server {\n\t\t\t\tstatus %d\n\t\t\t}\n", r.code)
+		}
+		buf.WriteString("\t\t}\n")
+	}
+	buf.WriteString(caddyfileEpilog)
+	return buf.Bytes(), nil
+}
+
+// https://caddyserver.com/docs/json/
+type caddyJSONEmitter struct{}
+
+func (caddyJSONEmitter) FileName() string {
+	return "caddy.json"
+}
+
+type caddyRoute struct {
+	Match    []map[string]interface{} `json:"match,omitempty"`
+	Handle   []map[string]interface{} `json:"handle"`
+	Terminal bool                     `json:"terminal,omitempty"`
+}
+
+func caddyFileServer(code int) map[string]interface{} {
+	res := map[string]interface{}{
+		"handler": "file_server",
+		"root":    "netlify_static",
+	}
+	if code != 200 {
+		res["status_code"] = code
+	}
+	return res
+}
+
+func (caddyJSONEmitter) Emit(redirects []*netlifyRedirect) ([]byte, error) {
+	notFile := map[string]interface{}{
+		"file": map[string]interface{}{
+			"root":      "netlify_static",
+			"try_files": []string{"{http.request.uri.path}", "{http.request.uri.path}/index.html", "{http.request.uri.path}.html"},
+		},
+	}
+	var routes []*caddyRoute
+	for i, r := range redirects {
+		name := fmt.Sprintf("r%d", i+1)
+		rule, err := newServerRule(r, caddyEscapeTo, caddyGroup(name))
+		if err != nil {
+			return nil, err
+		}
+		route := &caddyRoute{
+			Match: []map[string]interface{}{
+				{
+					"not": []interface{}{notFile},
+					"path_regexp": map[string]string{
+						"name":    name,
+						"pattern": rule.re,
+					},
+				},
+			},
+			Terminal: true,
+		}
+		if rule.isRedirect() {
+			route.Handle = []map[string]interface{}{
+				{
+					"handler":     "static_response",
+					"status_code": r.code,
+					"headers": map[string][]string{
+						"Location": {rule.to},
+					},
+				},
+			}
+		} else {
+			route.Handle = []map[string]interface{}{
+				{
+					"handler": "rewrite",
+					"uri":     rule.to,
+				},
+				caddyFileServer(r.code),
+			}
+		}
+		routes = append(routes, route)
+	}
+	routes = append(routes, &caddyRoute{
+		Handle: []map[string]interface{}{caddyFileServer(200)},
+	})
+	errorRoutes := []*caddyRoute{
+		{
+			Handle: []map[string]interface{}{
+				{
+					"handler": "rewrite",
+					"uri":     "/404.html",
+				},
+				caddyFileServer(200),
+			},
+		},
+	}
+	config := map[string]interface{}{
+		"apps": map[string]interface{}{
+			"http": map[string]interface{}{
+				"servers": map[string]interface{}{
+					"blog": map[string]interface{}{
+						"listen": []string{":8080"},
+						"routes": routes,
+						"errors": map[string]interface{}{
+							"routes": errorRoutes,
+						},
+					},
+				},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// urls with & would be escaped as \u0026
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(config)
+	return buf.Bytes(), err
+}
+
+// http://nginx.org/en/docs/http/ngx_http_rewrite_module.html
+type nginxEmitter struct{}
+
+func (nginxEmitter) FileName() string {
+	return "nginx.conf"
+}
+
+// nginx has no way to escape $ in strings so we url-encode it
+func nginxEscapeTo(s string) string {
+	return strings.Replace(s, "$", "%24", -1)
+}
+
+func nginxQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+func nginxGroup(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+const nginxProlog = `# generated by the blog build, don't edit
+# include in a server block with root set to netlify_static e.g.
+#   server {
+#     listen 8080;
+#     root /path/to/netlify_static;
+#     include /path/to/server_config/nginx.conf;
+#   }
+`
+
+func (nginxEmitter) Emit(redirects []*netlifyRedirect) ([]byte, error) {
+	pages, err := errorPages(redirects)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBufferString(nginxProlog)
+	for _, code := range []int{404, 410} {
+		if page := pages[code]; page != "" {
+			fmt.Fprintf(buf, "error_page %d %s;\n", code, nginxQuote(nginxEscapeTo(escapeRedirectURL(page))))
+		}
+	}
+	buf.WriteString("\nlocation / {\n\ttry_files $uri $uri/index.html $uri.html @redirects;\n}\n\n")
+	buf.WriteString("location @redirects {\n")
+	for _, r := range redirects {
+		rule, err := newServerRule(r, nginxEscapeTo, nginxGroup)
+		if err != nil {
+			return nil, err
+		}
+		switch r.code {
+		case 200:
+			fmt.Fprintf(buf, "\trewrite %s %s break;\n", nginxQuote(rule.re), nginxQuote(rule.to))
+		case 301:
+			fmt.Fprintf(buf, "\trewrite %s %s permanent;\n", nginxQuote(rule.re), nginxQuote(rule.to))
+		case 302:
+			fmt.Fprintf(buf, "\trewrite %s %s redirect;\n", nginxQuote(rule.re), nginxQuote(rule.to))
+		default:
+			fmt.Fprintf(buf, "\tif ($uri ~ %s) {\n\t\treturn %d;\n\t}\n", nginxQuote(rule.re), r.code)
+		}
+	}
+	buf.WriteString("\treturn 404;\n}\n")
+	return buf.Bytes(), nil
+}
+
+// https://httpd.apache.org/docs/current/mod/mod_rewrite.html
+type apacheEmitter struct{}
+
+func (apacheEmitter) FileName() string {
+	return ".htaccess"
+}
+
+// $ and % are back-references in mod_rewrite substitutions
+func apacheEscapeTo(s string) string {
+	s = strings.Replace(s, `$`, `\$`, -1)
+	return strings.Replace(s, `%`, `\%`, -1)
+}
+
+// apacheQuote quotes RewriteRule argument. mod_rewrite doesn't unescape
+// \\ in arguments so backslashes must be kept as they are. There are no
+// quotes to escape: they're \x22 in patterns and %22 in urls
+func apacheQuote(s string) string {
+	return `"` + s + `"`
+}
+
+func apacheGroup(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+const apacheProlog = `# generated by the blog build, don't edit
+# copy to netlify_static, needs mod_rewrite
+RewriteEngine On
+DirectoryIndex index.html
+`
+
+const apacheServeFiles = `
+# existing files are served without redirects, .html is optional
+RewriteCond %{REQUEST_FILENAME} -f [OR]
+RewriteCond %{REQUEST_FILENAME}/index.html -f
+RewriteRule ^ - [END]
+RewriteCond %{REQUEST_FILENAME}.html -f
+RewriteRule ^(.*)$ $1.html [END]
+
+`
+
+func (apacheEmitter) Emit(redirects []*netlifyRedirect) ([]byte, error) {
+	pages, err := errorPages(redirects)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBufferString(apacheProlog)
+	for _, code := range []int{404, 410} {
+		page := pages[code]
+		if page == "" {
+			continue
+		}
+		// quoted ErrorDocument is a message, not url, but escaped url
+		// has no spaces or quotes
+		fmt.Fprintf(buf, "ErrorDocument %d %s\n", code, apacheEscapeTo(escapeRedirectURL(page)))
+	}
+	buf.WriteString(apacheServeFiles)
+	for _, r := range redirects {
+		rule, err := newServerRule(r, apacheEscapeTo, apacheGroup)
+		if err != nil {
+			return nil, err
+		}
+		// in .htaccess the path we match doesn't start with /
+		re := "^" + strings.TrimPrefix(rule.re, "^/")
+		switch r.code {
+		case 200:
+			fmt.Fprintf(buf, "RewriteRule %s %s [END]\n", apacheQuote(re), apacheQuote(rule.to))
+		case 301, 302:
+			fmt.Fprintf(buf, "RewriteRule %s %s [R=%d,L]\n", apacheQuote(re), apacheQuote(rule.to), r.code)
+		case 404:
+			fmt.Fprintf(buf, "RewriteRule %s - [R=404,L]\n", apacheQuote(re))
+		case 410:
+			fmt.Fprintf(buf, "RewriteRule %s - [G]\n", apacheQuote(re))
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var flgUpdateGolden = flag.Bool("update-golden", false, "if true, re-generates golden files in testdata")
+
+// testEmitterRedirects covers what we generate and characters that are
+// special in configs of some server
+var testEmitterRedirects = []*netlifyRedirect{
+	{from: "/article/:id/*", to: "/article/:id.html", code: 200},
+	{from: "/", to: "/index.html", code: 200},
+	{from: "/blog/", to: "/", code: 302},
+	{from: "/feed", to: "/atom.xml", code: 301},
+	{from: "/software/sumatrapdf*", to: "https://www.sumatrapdfreader.org/:splat", code: 302},
+	{from: "/favicon.ico", to: "/static/favicon.ico", code: 200},
+	{from: "/tag/objective c", to: "/article/archives-by-tag-objective-c.html", code: 200},
+	{from: "/tag/c++", to: "/article/archives-by-tag-c++.html", code: 200},
+	{from: "/kb/serialization-in-c#.html", to: "/article/Serialization-in-C.html", code: 301},
+	{from: `/say-"hi"`, to: "/hi.html", code: 302},
+	{from: "/price", to: "/cost-$5-100%.html", code: 302},
+	{from: "/tmpl", to: "/{{page}}.html", code: 302},
+	{from: `/regexp\d`, to: "/regexp.html", code: 302},
+	{from: "/article/Diet.html", to: goneURL, code: 410},
+	{from: "/missing", to: "/404.html", code: 404},
+}
+
+func TestRedirectEmitters(t *testing.T) {
+	emitters := append([]RedirectEmitter{netlifyEmitter{}}, redirectEmitters...)
+	for _, e := range emitters {
+		got, err := e.Emit(testEmitterRedirects)
+		assert.NoError(t, err)
+		path := filepath.Join("testdata", "redirects", e.FileName())
+		if *flgUpdateGolden {
+			err = ioutil.WriteFile(path, got, 0644)
+			assert.NoError(t, err)
+			continue
+		}
+		exp, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, string(exp), string(got), "%s differs, run go test -update-golden if the change is expected", path)
+	}
+}
+
+// mod_rewrite passes quoted RewriteRule arguments as they are, so the
+// pattern must be a valid regexp matching the path without leading /
+func TestApacheEmitterPatterns(t *testing.T) {
+	d, err := apacheEmitter{}.Emit(testEmitterRedirects)
+	assert.NoError(t, err)
+	patterns := map[string]*regexp.Regexp{}
+	for _, l := range strings.Split(string(d), "\n") {
+		if !strings.HasPrefix(l, `RewriteRule "`) {
+			continue
+		}
+		parts := strings.Split(l, `"`)
+		re, err := regexp.Compile(parts[1])
+		if assert.NoError(t, err, "%s", l) {
+			patterns[parts[1]] = re
+		}
+		if len(parts) > 3 {
+			assert.NotContains(t, parts[3], `\\`, "%s", l)
+		}
+	}
+	assert.Equal(t, len(testEmitterRedirects), len(patterns))
+	for _, uri := range []string{"/favicon.ico", "/tag/objective c", "/tag/c++/", `/say-"hi"`, "/kb/serialization-in-c#.html", `/regexp\d`} {
+		matched := 0
+		for _, re := range patterns {
+			if re.MatchString(strings.TrimPrefix(uri, "/")) {
+				matched++
+			}
+		}
+		assert.Equal(t, 1, matched, "%s", uri)
+	}
+}
+
+func TestRedirectEmittersErrors(t *testing.T) {
+	bad := [][]*netlifyRedirect{
+		{{from: "/a", to: "/b", code: 303}},
+		{{from: "/a", to: "/a.html", code: 410}, {from: "/b", to: "/b.html", code: 410}},
+		{{from: "/a", to: "/b.html", code: 200, force: true}},
+	}
+	for _, redirects := range bad {
+		for _, e := range []RedirectEmitter{nginxEmitter{}, apacheEmitter{}} {
+			_, err := e.Emit(redirects)
+			assert.Error(t, err, "%s", e.FileName())
+		}
+	}
+	d, err := netlifyEmitter{}.Emit([]*netlifyRedirect{{from: "/a", to: "/b.html", code: 200, force: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, "/a\t/b.html\t200!\n", string(d))
+}
+
+func TestEscapeRedirectURL(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp string
+	}{
+		{"/tag/objective c", "/tag/objective%20c"},
+		{"/tag/c++/atom.xml", "/tag/c++/atom.xml"},
+		{"/article/:id/*", "/article/:id/*"},
+		{"/kb/serialization-in-c#.html", "/kb/serialization-in-c%23.html"},
+		{"/cost-$5-100%.html", "/cost-$5-100%25.html"},
+		{"/zażółć", "/za%C5%BC%C3%B3%C5%82%C4%87"},
+		{"https://example.com/a b?q=%20#x", "https://example.com/a%20b?q=%20#x"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.exp, escapeRedirectURL(test.s))
+	}
+}
+
+func TestNetlifyEmitterRoundTrip(t *testing.T) {
+	d, err := netlifyEmitter{}.Emit(testEmitterRedirects)
+	assert.NoError(t, err)
+	got, err := parseNetlifyRedirects(d)
+	assert.NoError(t, err)
+	assert.Equal(t, testEmitterRedirects, got)
+}
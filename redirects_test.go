@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRedirects(t *testing.T) {
+	d := `# comment
+version 2
+
+/blog      /
+/feed/     https://example.com/atom.xml 301
+/diet.html article:3
+/old.html  article:abc 410
+`
+	entries, err := parseRedirects([]byte(d))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(entries))
+	assert.Equal(t, &redirectEntry{from: "/blog", to: "/", code: 302, line: 4}, entries[0])
+	assert.Equal(t, "https://example.com/atom.xml", entries[1].to)
+	assert.Equal(t, 301, entries[1].code)
+	assert.Equal(t, "3", entries[2].articleID)
+	assert.Equal(t, "article:abc", entries[3].target())
+	assert.Equal(t, 410, entries[3].status())
+
+	// version 1 didn't have status codes
+	_, err = parseRedirects([]byte("version 1\n/blog /\n"))
+	assert.NoError(t, err)
+
+	bad := []string{
+		"",
+		"/blog /\n",
+		"version 3\n/blog /\n",
+		"version x\n",
+		"version 1\n/blog\n",
+		"version 1\n/blog / 302\n",
+		"version 2\n/blog / 303\n",
+		"version 2\n/blog / 302 x\n",
+		"version 1\nblog /\n",
+		"version 1\n/blog blog.html\n",
+		"version 1\n/blog article:\n",
+	}
+	for _, s := range bad {
+		_, err = parseRedirects([]byte(s))
+		assert.Error(t, err, "%q", s)
+	}
+}
+
+func TestValidateRedirects(t *testing.T) {
+	a := &Article{ID: "abc", Title: "Diet"}
+	deleted := &Article{ID: "del", Title: "Deleted", Status: statusDeleted}
+	store := &Articles{
+		articles:    []*Article{a, deleted},
+		idToArticle: map[string]*Article{"abc": a, "del": deleted},
+	}
+	d := `version 1
+/blog              /
+/blog/             /
+/feed              /atom.xml
+/feed/             /rss.xml
+/diet.html         article:abc
+/gone.html         article:xyz
+/article/abc/diet.html /
+/a.html            /b.html
+/b.html            /diet.html
+/loop1.html        /loop2.html
+/loop2.html        /loop1.html/
+/deleted.html      article:del
+/c.html            /deleted.html
+`
+	entries, err := parseRedirects([]byte(d))
+	assert.NoError(t, err)
+	valid, problems := validateRedirects(entries, store)
+	var froms []string
+	for _, e := range valid {
+		froms = append(froms, e.from)
+	}
+	exp := []string{"/blog", "/feed", "/diet.html", "/a.html", "/b.html", "/loop1.html", "/loop2.html", "/deleted.html", "/c.html"}
+	assert.Equal(t, exp, froms)
+	assert.Equal(t, "/article/abc/diet.html", valid[2].to)
+	assert.Equal(t, 302, valid[2].status())
+	assert.Equal(t, goneURL, valid[7].to)
+	assert.Equal(t, 410, valid[7].status())
+	expProblems := []string{
+		"line 3: /blog/: duplicate of line 2",
+		"line 5: /feed/: redirects to /rss.xml but line 4 redirects to /atom.xml",
+		"line 7: /gone.html: article 'xyz' no longer exists",
+		"line 8: /article/abc/diet.html: is the url of article 'Diet' so it's never redirected",
+		"line 9: /a.html: chain of redirects /a.html => /b.html => /diet.html => /article/abc/diet.html",
+		"line 10: /b.html: chain of redirects /b.html => /diet.html => /article/abc/diet.html",
+		"line 11: /loop1.html: redirect loop /loop1.html => /loop2.html => /loop1.html/",
+		"line 12: /loop2.html: redirect loop /loop2.html => /loop1.html/ => /loop2.html",
+		"line 14: /c.html: redirects to /deleted.html which is gone (line 13)",
+	}
+	assert.Equal(t, expProblems, problems)
+}
+
+func TestReplayRedirects(t *testing.T) {
+	dir := writeTestSite(t, "index.html", "atom.xml", "article/abc.html")
+	defer os.RemoveAll(dir)
+	r, err := newNetlifyResolverFromDir(dir)
+	assert.NoError(t, err)
+	d := `version 1
+/blog                   /
+/feed/                  /atom.xml
+/software/sumatrapdf    https://www.sumatrapdfreader.org/free-pdf-reader.html
+/gone                   /missing.html
+/article/abc/title.html article:abc
+/not-redirected.html    article:xyz
+`
+	entries, err := parseRedirects([]byte(d))
+	assert.NoError(t, err)
+	var got []string
+	var broken []string
+	for _, l := range replayRedirects(r, entries) {
+		got = append(got, l.String())
+		if l.isBroken() {
+			broken = append(broken, l.entry.from)
+		}
+	}
+	exp := []string{
+		"line 2: /blog => / (200)",
+		"line 3: /feed/ => /atom.xml (200)",
+		"line 4: /software/sumatrapdf => https://www.sumatrapdfreader.org/ (302)",
+		"line 5: /gone (404)",
+		"line 6: /article/abc/title.html (200)",
+		"line 7: /not-redirected.html (404)",
+	}
+	assert.Equal(t, exp, got)
+	assert.Equal(t, []string{"/gone", "/not-redirected.html"}, broken)
+}
+
+func TestApplyRedirectChanges(t *testing.T) {
+	d := `# comment
+version 1
+/blog       /
+/feed       /atom.xml
+/diet.html  article:3
+`
+	entries, err := parseRedirects([]byte(d))
+	assert.NoError(t, err)
+	changes := []*redirectChange{
+		{entries[0], 301},
+		{entries[2], 410},
+	}
+	got := string(applyRedirectChanges([]byte(d), changes))
+	exp := `# comment
+version 2
+/blog       / 301
+/feed       /atom.xml
+/diet.html  article:3 410
+`
+	assert.Equal(t, exp, got)
+
+	entries, err = parseRedirects([]byte(got))
+	assert.NoError(t, err)
+	got = string(applyRedirectChanges([]byte(got), []*redirectChange{{entries[0], 302}}))
+	assert.Equal(t, strings.Replace(exp, "/ 301", "/ 302", 1), got)
+}
@@ -88,13 +88,18 @@ func mdToHTML(mdFile, templateFile, htmlFile string) {
 	title := meta[keyTitle]
 	model[keyTitle] = title
 	model["BodyHTML"] = template.HTML(body)
+	model["AnalyticsCode"] = site.AnalyticsCode
 
 	templateName := filepath.Base(templateFile)
-	templates = template.Must(template.ParseFiles(templateFile))
+	// don't use templates global, it's for site templates
+	tmpl, err := template.ParseFiles(templateFile, findTemplate(tmplAnalytics))
+	panicIfErr(err)
 	var buf bytes.Buffer
-	err = templates.ExecuteTemplate(&buf, templateName, model)
+	err = tmpl.ExecuteTemplate(&buf, templateName, model)
 	panicIfErr(err)
-	err = ioutil.WriteFile(htmlFile, buf.Bytes(), 0644)
+	// don't touch the file if it didn't change, so that incremental
+	// build doesn't think it needs to be copied again
+	err = writeFileIfChanged(htmlFile, buf.Bytes())
 	panicIfErr(err)
 	fmt.Printf("%s => %s\n", mdFile, htmlFile)
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// html of all articles, from notion and markdown, goes through sanitizeHTML.
+// The policy is bluemonday's policy for user generated content, extended
+// with what our renderers generate. Embeds are allowed only from hosts
+// listed in site config:
+// - iframes from site.IframeHosts
+// - gists from site.GistHosts. bluemonday can't safely allow <script> so
+//   renderers generate a link to a gist (see gistLink) and we turn it into
+//   <script> after sanitizing
+
+var (
+	reNumber      = regexp.MustCompile(`^[0-9]+$`)
+	reSVGPoints   = regexp.MustCompile(`^[0-9., ]+$`)
+	reIframeAllow = regexp.MustCompile(`^[a-z; -]*$`)
+	reBoolAttr    = regexp.MustCompile(`^(|true|[a-z]+)$`)
+	reHTTPURL     = regexp.MustCompile(`^https?://`)
+	reGistLink    = regexp.MustCompile(`<a class="gist" href="([^"]+)">[^<]*</a>`)
+	reColumnStyle = regexp.MustCompile(`^flex: [0-9]+\.[0-9]+ 1 0%$`)
+	reImgSrcSet   = regexp.MustCompile(`^/img/[A-Za-z0-9._-]+ [0-9]+w(, /img/[A-Za-z0-9._-]+ [0-9]+w)*$`)
+	reImgSizes    = regexp.MustCompile(`^[a-z0-9(): ,-]+$`)
+	reMathLength  = regexp.MustCompile(`^-?[0-9.]+(em|px)$`)
+	reMathWords   = regexp.MustCompile(`^[a-z -]+$`)
+	reTrueFalse   = regexp.MustCompile(`^(true|false)$`)
+)
+
+// gistLink returns html for a gist, which sanitizeHTML turns into an embed
+func gistLink(uri string) string {
+	uri = html.EscapeString(uri)
+	return fmt.Sprintf(`<a class="gist" href="%s">%s</a>`, uri, uri)
+}
+
+// hostsRegex returns a regex matching https:// urls on one of hosts
+func hostsRegex(hosts []string) *regexp.Regexp {
+	var quoted []string
+	for _, host := range hosts {
+		quoted = append(quoted, regexp.QuoteMeta(host))
+	}
+	return regexp.MustCompile(`^https://(` + strings.Join(quoted, "|") + `)/`)
+}
+
+func newSanitizePolicy(c *siteConfig) *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowStyling()
+	policy.RequireNoFollowOnFullyQualifiedLinks(false)
+	policy.RequireNoFollowOnLinks(false)
+
+	// arrows of toggles
+	policy.AllowAttrs("viewbox").Matching(reSVGPoints).OnElements("svg")
+	policy.AllowAttrs("points").Matching(reSVGPoints).OnElements("polygon")
+
+	// width of notion columns
+	policy.AllowAttrs("style").Matching(reColumnStyle).OnElements("div")
+
+	// see genImage. alt is escaped so it can be any text
+	policy.AllowAttrs("alt").OnElements("img")
+	policy.AllowAttrs("srcset").Matching(reImgSrcSet).OnElements("img")
+	policy.AllowAttrs("sizes").Matching(reImgSizes).OnElements("img")
+	policy.AllowAttrs("loading").Matching(reBoolAttr).OnElements("img", "iframe")
+
+	policy.AllowAttrs("src").Matching(reHTTPURL).OnElements("audio")
+	policy.AllowAttrs("controls").Matching(reBoolAttr).OnElements("audio")
+	policy.AllowAttrs("preload").Matching(reBoolAttr).OnElements("audio")
+
+	// equations, see equationHTML
+	policy.AllowNoAttrs().OnElements("math", "semantics", "annotation", "mrow",
+		"mi", "mn", "mo", "mtext", "mspace", "msup", "msub", "msubsup", "mover",
+		"munder", "munderover", "mfrac", "msqrt", "mroot", "mtable", "mtr",
+		"mtd", "mstyle")
+	policy.AllowAttrs("xmlns").Matching(regexp.MustCompile(`^` + regexp.QuoteMeta(mathMLNamespace) + `$`)).OnElements("math")
+	policy.AllowAttrs("display").Matching(regexp.MustCompile(`^(block|inline)$`)).OnElements("math")
+	policy.AllowAttrs("encoding").Matching(regexp.MustCompile(`^application/x-tex$`)).OnElements("annotation")
+	policy.AllowAttrs("mathvariant").Matching(reMathWords).OnElements("mi")
+	policy.AllowAttrs("fence", "stretchy").Matching(reTrueFalse).OnElements("mo")
+	policy.AllowAttrs("minsize", "maxsize").Matching(reMathLength).OnElements("mo")
+	policy.AllowAttrs("width").Matching(reMathLength).OnElements("mspace")
+	policy.AllowAttrs("linebreak").Matching(regexp.MustCompile(`^newline$`)).OnElements("mspace")
+	policy.AllowAttrs("accent").Matching(reTrueFalse).OnElements("mover")
+	policy.AllowAttrs("accentunder").Matching(reTrueFalse).OnElements("munder")
+	policy.AllowAttrs("linethickness").Matching(reMathLength).OnElements("mfrac")
+	policy.AllowAttrs("rowspacing").Matching(reMathLength).OnElements("mtable")
+	policy.AllowAttrs("columnalign").Matching(reMathWords).OnElements("mtable")
+	policy.AllowAttrs("displaystyle").Matching(reTrueFalse).OnElements("mstyle")
+	policy.AllowAttrs("scriptlevel").Matching(reNumber).OnElements("mstyle")
+
+	if c != nil && len(c.IframeHosts) > 0 {
+		policy.AllowAttrs("src").Matching(hostsRegex(c.IframeHosts)).OnElements("iframe")
+		policy.AllowAttrs("width", "height", "frameborder").Matching(reNumber).OnElements("iframe")
+		policy.AllowAttrs("allow").Matching(reIframeAllow).OnElements("iframe")
+		policy.AllowAttrs("allowfullscreen").Matching(reBoolAttr).OnElements("iframe")
+	}
+	return policy
+}
+
+// embedGists replaces links generated by gistLink with gist embeds
+func embedGists(s string, c *siteConfig) string {
+	return reGistLink.ReplaceAllStringFunc(s, func(link string) string {
+		uri := html.UnescapeString(reGistLink.FindStringSubmatch(link)[1])
+		if c == nil || !c.isAllowedGistURL(uri) {
+			return link
+		}
+		return fmt.Sprintf(`<script src="%s.js"></script>`, html.EscapeString(uri))
+	})
+}
+
+func sanitizeHTML(unsafe []byte) string {
+	policy := newSanitizePolicy(site)
+	res := policy.SanitizeBytes(unsafe)
+	return embedGists(string(res), site)
+}
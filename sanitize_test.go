@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	setTestSiteConfig()
+	site.IframeHosts = []string{"www.youtube.com"}
+	site.GistHosts = []string{"gist.github.com"}
+	tests := []struct {
+		s   string
+		exp string
+	}{
+		{`<p>a<script>alert(1)</script></p>`, `<p>a</p>`},
+		{`<a href="javascript:alert(1)" onclick="x()">a</a>`, `a`},
+		{`<div class="toggle" style="color:red">a</div>`, `<div class="toggle">a</div>`},
+		{
+			`<iframe src="https://www.youtube.com/embed/x" width="640" height="480" allowfullscreen></iframe>`,
+			`<iframe src="https://www.youtube.com/embed/x" width="640" height="480" allowfullscreen=""></iframe>`,
+		},
+		{`<iframe src="https://evil.com/x" width="640"></iframe>`, `<iframe width="640"></iframe>`},
+		{`<iframe src="https://www.youtube.com.evil.com/x"></iframe>`, ``},
+		{
+			`<svg class="toggle-opener" viewBox="0 0 100 100"><polygon points="5.9,88.2 50,11.8"></polygon></svg>`,
+			`<svg class="toggle-opener" viewbox="0 0 100 100"><polygon points="5.9,88.2 50,11.8"></polygon></svg>`,
+		},
+		{gistLink("https://gist.github.com/kjk/1234"), `<script src="https://gist.github.com/kjk/1234.js"></script>`},
+		{gistLink("https://gist.evil.com/kjk/1234"), `<a class="gist" href="https://gist.evil.com/kjk/1234">https://gist.evil.com/kjk/1234</a>`},
+		{`<script src="https://gist.github.com/kjk/1234.js"></script>`, ``},
+		{`<div class="column" style="flex: 33.33 1 0%">a</div>`, `<div class="column" style="flex: 33.33 1 0%">a</div>`},
+		{`<div style="flex: 1 1 0%;background:url(x)">a</div>`, `<div>a</div>`},
+		{
+			`<img src="/img/a.jpg" alt="a: &#34;b&#34;" srcset="/img/a-480w.jpg 480w, /img/a.jpg 800w" sizes="(max-width: 800px) 100vw, 800px" loading="lazy">`,
+			`<img src="/img/a.jpg" alt="a: &#34;b&#34;" srcset="/img/a-480w.jpg 480w, /img/a.jpg 800w" sizes="(max-width: 800px) 100vw, 800px" loading="lazy">`,
+		},
+		{`<img src="/img/a.jpg" srcset="javascript:x 1w">`, `<img src="/img/a.jpg">`},
+		{mustEquationHTML(`\frac{\alpha}{\sqrt[3]{x}} \leq \sum_{i=1}^n \mathbb{R}_i`, true), mustEquationHTML(`\frac{\alpha}{\sqrt[3]{x}} \leq \sum_{i=1}^n \mathbb{R}_i`, true)},
+		{mustEquationHTML(`\begin{pmatrix} a & b \\ c & d \end{pmatrix} \left( \hat{x} \right) \, \underline{y} \binom{n}{k}`, false), mustEquationHTML(`\begin{pmatrix} a & b \\ c & d \end{pmatrix} \left( \hat{x} \right) \, \underline{y} \binom{n}{k}`, false)},
+		{`<math xmlns="http://evil.com" display="x"><mi mathvariant="url(x)" onclick="x()">a</mi></math>`, `<math><mi>a</mi></math>`},
+	}
+	for _, test := range tests {
+		got := sanitizeHTML([]byte(test.s))
+		assert.Equal(t, test.exp, got, "s: '%s'", test.s)
+	}
+}
+
+func TestIsAllowedIframeURL(t *testing.T) {
+	c := &siteConfig{
+		IframeHosts: []string{"www.youtube.com"},
+	}
+	assert.True(t, c.isAllowedIframeURL("https://www.youtube.com/embed/x"))
+	assert.False(t, c.isAllowedIframeURL("http://www.youtube.com/embed/x"))
+	assert.False(t, c.isAllowedIframeURL("https://youtube.com/embed/x"))
+	assert.False(t, c.isAllowedIframeURL("https://www.youtube.com@evil.com/"))
+	assert.False(t, c.isAllowedIframeURL("javascript:alert(1)"))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// We generate an inverted index for client-side search (see
+// search.tmpl.html). It's static json files in /search/:
+// - docs.json is an array of documents: title, url, description
+// - idx-${shard}.json maps tokens to postings. Tokens are sharded by their
+//   first 2 characters so that a query only loads a few small files
+// Postings are a flat array: [doc, score, doc, score...], where doc is an
+// index in docs.json and score says how important a token is in a document.
+// Tokenization must be the same here and in search.tmpl.html
+
+const (
+	searchIndexDir = "/search"
+	// tokens shorter than that are not indexed
+	searchMinTokenLen = 2
+	// tokens longer than that are truncated. Long tokens are rarely
+	// searched for and are usually garbage like base64 data
+	searchMaxTokenLen = 24
+
+	searchScoreTitle       = 10
+	searchScoreTag         = 5
+	searchScoreDescription = 3
+	searchScoreBody        = 1
+)
+
+// common english words that would be in almost every document
+var searchStopWords = map[string]bool{
+	"an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "if": true,
+	"in": true, "into": true, "is": true, "it": true, "no": true,
+	"not": true, "of": true, "on": true, "or": true, "such": true,
+	"that": true, "the": true, "their": true, "then": true, "there": true,
+	"these": true, "they": true, "this": true, "to": true, "was": true,
+	"will": true, "with": true, "you": true, "your": true, "we": true,
+	"can": true, "have": true, "has": true, "from": true, "so": true,
+}
+
+func isSearchTokenChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// searchTokenize splits s into lower-cased words, without stop words
+func searchTokenize(s string) []string {
+	var res []string
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !isSearchTokenChar(r)
+	})
+	for _, w := range words {
+		runes := []rune(w)
+		if len(runes) < searchMinTokenLen || searchStopWords[w] {
+			continue
+		}
+		if len(runes) > searchMaxTokenLen {
+			w = string(runes[:searchMaxTokenLen])
+		}
+		res = append(res, w)
+	}
+	return res
+}
+
+// searchShardKey returns name of the shard for a token: its first
+// 2 characters if they're ascii letters or digits, "_" otherwise
+func searchShardKey(token string) string {
+	key := ""
+	for _, r := range token {
+		if len(key) == 2 || r > unicode.MaxASCII {
+			break
+		}
+		key += string(r)
+	}
+	if key == "" {
+		return "_"
+	}
+	return key
+}
+
+var (
+	reHTMLScriptOrStyle = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	reHTMLTag           = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// htmlToText returns text of html. It's good enough for our own html,
+// not for arbitrary html
+func htmlToText(s string) string {
+	s = reHTMLScriptOrStyle.ReplaceAllString(s, " ")
+	s = reHTMLTag.ReplaceAllString(s, " ")
+	return html.UnescapeString(s)
+}
+
+type searchDoc struct {
+	Title       string `json:"t"`
+	URL         string `json:"u"`
+	Description string `json:"d,omitempty"`
+}
+
+type searchIndex struct {
+	docs []*searchDoc
+	// token => doc => score
+	tokens map[string]map[int]int
+}
+
+func (idx *searchIndex) addText(doc int, s string, score int) {
+	for _, tok := range searchTokenize(s) {
+		m := idx.tokens[tok]
+		if m == nil {
+			m = map[int]int{}
+			idx.tokens[tok] = m
+		}
+		m[doc] += score
+	}
+}
+
+// buildSearchIndex indexes articles that are not hidden
+func buildSearchIndex(articles []*Article) *searchIndex {
+	// sorted so that the index doesn't change between builds
+	var toIndex []*Article
+	for _, a := range articles {
+		if a.IsHidden() {
+			continue
+		}
+		toIndex = append(toIndex, a)
+	}
+	sort.Slice(toIndex, func(i, j int) bool {
+		return toIndex[i].ID < toIndex[j].ID
+	})
+
+	idx := &searchIndex{
+		tokens: map[string]map[int]int{},
+	}
+	for i, a := range toIndex {
+		doc := &searchDoc{
+			Title:       a.Title,
+			URL:         a.URL(),
+			Description: a.Description,
+		}
+		idx.docs = append(idx.docs, doc)
+		idx.addText(i, a.Title, searchScoreTitle)
+		idx.addText(i, strings.Join(a.Tags, " "), searchScoreTag)
+		idx.addText(i, a.Description, searchScoreDescription)
+		idx.addText(i, htmlToText(a.BodyHTML), searchScoreBody)
+	}
+	return idx
+}
+
+// shards returns tokens with postings, grouped by shard key
+func (idx *searchIndex) shards() map[string]map[string][]int {
+	res := map[string]map[string][]int{}
+	for tok, docToScore := range idx.tokens {
+		var docs []int
+		for doc := range docToScore {
+			docs = append(docs, doc)
+		}
+		sort.Ints(docs)
+		var postings []int
+		for _, doc := range docs {
+			postings = append(postings, doc, docToScore[doc])
+		}
+		key := searchShardKey(tok)
+		shard := res[key]
+		if shard == nil {
+			shard = map[string][]int{}
+			res[key] = shard
+		}
+		shard[tok] = postings
+	}
+	return res
+}
+
+// netlifyWriteSearchIndex writes search index for articles to /search/
+func netlifyWriteSearchIndex(articles []*Article) {
+	idx := buildSearchIndex(articles)
+	// json.Marshal sorts map keys so the output is stable
+	d, err := json.Marshal(idx.docs)
+	panicIfErr(err)
+	netlifyWriteFile(searchIndexDir+"/docs.json", d)
+
+	shards := idx.shards()
+	for key, shard := range shards {
+		d, err := json.Marshal(shard)
+		panicIfErr(err)
+		netlifyWriteFile(searchIndexDir+"/idx-"+key+".json", d)
+	}
+	logVerbose("search index: %d documents, %d tokens, %d shards\n", len(idx.docs), len(idx.tokens), len(shards))
+}
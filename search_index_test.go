@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchTokenize(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp []string
+	}{
+		{"", nil},
+		{"The Go programming language", []string{"go", "programming", "language"}},
+		{"C++ and x", nil},
+		{"don't-stop, Zażółć", []string{"don", "stop", "zażółć"}},
+		{"abcdefghijklmnopqrstuvwxyz0123", []string{"abcdefghijklmnopqrstuvwx"}},
+	}
+	for _, test := range tests {
+		got := searchTokenize(test.s)
+		assert.Equal(t, test.exp, got, "s: '%s'", test.s)
+	}
+}
+
+func TestSearchShardKey(t *testing.T) {
+	assert.Equal(t, "go", searchShardKey("golang"))
+	assert.Equal(t, "c9", searchShardKey("c99"))
+	assert.Equal(t, "za", searchShardKey("zażółć"))
+	assert.Equal(t, "_", searchShardKey("żółw"))
+}
+
+func TestHTMLToText(t *testing.T) {
+	s := htmlToText(`<p>a &amp; b</p><script>var x = "<p>";</script><style>p {}</style>c`)
+	assert.Equal(t, searchTokenize("a & b c"), searchTokenize(s))
+	assert.NotContains(t, s, "var")
+}
+
+func TestBuildSearchIndex(t *testing.T) {
+	setTestSiteConfig()
+	articles := []*Article{
+		{ID: "b", Title: "Go tips", Tags: []string{"go"}, BodyHTML: "<p>go go</p>"},
+		{ID: "a", Title: "Hidden", Status: statusHidden, BodyHTML: "<p>go</p>"},
+		{ID: "c", Title: "Notes", Description: "on go"},
+	}
+	idx := buildSearchIndex(articles)
+	assert.Equal(t, 2, len(idx.docs))
+	assert.Equal(t, "Go tips", idx.docs[0].Title)
+	assert.Equal(t, map[int]int{0: 10 + 5 + 2, 1: 3}, idx.tokens["go"])
+	assert.Nil(t, idx.tokens["hidden"])
+
+	shards := idx.shards()
+	assert.Equal(t, []int{0, 17, 1, 3}, shards["go"]["go"])
+}
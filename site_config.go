@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Things that differ between sites (and between production and staging
+// of the same site) are in a config file, by default site.json.
+// Unknown keys are errors so that a typo doesn't silently use a default.
+
+var (
+	siteConfigPath = "site.json"
+
+	// set by setSiteConfig
+	site *siteConfig
+	// hash of site config, for incremental builds
+	siteConfigHash string
+)
+
+// siteCollection describes a collection articles can belong to,
+// set with "collection: " metadata
+type siteCollection struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+type siteConfig struct {
+	// e.g. https://blog.kowalczyk.info, without trailing slash
+	Host string `json:"host"`
+	// Google Analytics code, if empty we don't include analytics
+	AnalyticsCode string `json:"analytics_code"`
+	FeedTitle     string `json:"feed_title"`
+	// author of all entries in feeds, optional
+	FeedAuthor string `json:"feed_author"`
+	// e.g. @kjk
+	TwitterHandle string `json:"twitter_handle"`
+	// we start crawling notion from this page. It's also the index page
+	NotionStartPage string `json:"notion_start_page"`
+	// pages that are not shown in navigation bread-crumbs
+	NotionRootPages []string `json:"notion_root_pages"`
+	// maps value of "collection: " metadata to a collection
+	Collections map[string]*siteCollection `json:"collections"`
+	// values of "collection: " metadata we accept but ignore
+	IgnoredCollections []string `json:"ignored_collections"`
+	// hosts that articles can embed with <iframe> e.g. www.youtube.com
+	IframeHosts []string `json:"iframe_hosts"`
+	// hosts of gists that articles can embed with <script>
+	GistHosts []string `json:"gist_hosts"`
+	// headers for paths in _headers, override headers we generate.
+	// Path is exact or ends with /* e.g. "/tools/*". Empty value removes a header
+	Headers map[string]map[string]string `json:"headers"`
+}
+
+// validate returns an error listing all problems with the config
+func (c *siteConfig) validate() error {
+	var errs []string
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if c.Host == "" {
+		addErr("host is missing")
+	} else if u, err := url.Parse(c.Host); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		addErr("host '%s' is not an http:// or https:// url", c.Host)
+	} else if u.Path != "" || u.RawQuery != "" {
+		addErr("host '%s' should not have a path, not even a trailing slash", c.Host)
+	}
+	if c.FeedTitle == "" {
+		addErr("feed_title is missing")
+	}
+	if c.TwitterHandle != "" && !strings.HasPrefix(c.TwitterHandle, "@") {
+		addErr("twitter_handle '%s' should start with @", c.TwitterHandle)
+	}
+	if c.NotionStartPage == "" {
+		addErr("notion_start_page is missing")
+	} else if !isValidNotionID(c.NotionStartPage) {
+		addErr("notion_start_page '%s' is not a valid notion id", c.NotionStartPage)
+	}
+	for _, id := range c.NotionRootPages {
+		if !isValidNotionID(id) {
+			addErr("notion_root_pages: '%s' is not a valid notion id", id)
+		}
+	}
+
+	var names []string
+	for name := range c.Collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		coll := c.Collections[name]
+		if coll == nil || coll.Title == "" {
+			addErr("collections: '%s' has no title", name)
+			continue
+		}
+		if !strings.HasPrefix(coll.URL, "/") {
+			addErr("collections: url '%s' of '%s' should start with /", coll.URL, name)
+		}
+	}
+	for _, name := range c.IgnoredCollections {
+		if c.Collections[name] != nil {
+			addErr("ignored_collections: '%s' is also in collections", name)
+		}
+	}
+
+	for _, host := range append(c.IframeHosts, c.GistHosts...) {
+		if !isValidHostName(host) {
+			addErr("'%s' in iframe_hosts or gist_hosts is not a host name like www.youtube.com", host)
+		}
+	}
+
+	var paths []string
+	for p := range c.Headers {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if !isValidHeadersPath(p) {
+			addErr("headers: path '%s' should start with / and can only end with /*", p)
+		}
+		var headerNames []string
+		for name := range c.Headers[p] {
+			headerNames = append(headerNames, name)
+		}
+		sort.Strings(headerNames)
+		for _, name := range headerNames {
+			v := c.Headers[p][name]
+			if !headerNameRegex.MatchString(name) {
+				addErr("headers: '%s' of '%s' is not a valid header name", name, p)
+			} else if strings.ContainsAny(v, "\r\n") {
+				addErr("headers: value of '%s' of '%s' has a new line", name, p)
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(errs, "\n"))
+}
+
+func (c *siteConfig) isNotionRootPage(id string) bool {
+	id = normalizeID(id)
+	if id == c.NotionStartPage {
+		return true
+	}
+	for _, rootID := range c.NotionRootPages {
+		if id == rootID {
+			return true
+		}
+	}
+	return false
+}
+
+var hostNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+func isValidHostName(s string) bool {
+	return hostNameRegex.MatchString(s)
+}
+
+var headerNameRegex = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+
+// isValidHeadersPath returns true if p can be a path in _headers
+func isValidHeadersPath(p string) bool {
+	if !strings.HasPrefix(p, "/") || strings.ContainsAny(p, " \t") {
+		return false
+	}
+	i := strings.Index(p, "*")
+	return i == -1 || (i == len(p)-1 && strings.HasSuffix(p, "/*"))
+}
+
+// isHTTPSURLOnHost returns true if uri is an https:// url on one of hosts
+func isHTTPSURLOnHost(uri string, hosts []string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "https" || u.User != nil {
+		return false
+	}
+	for _, host := range hosts {
+		if u.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *siteConfig) isAllowedIframeURL(uri string) bool {
+	return isHTTPSURLOnHost(uri, c.IframeHosts)
+}
+
+func (c *siteConfig) isAllowedGistURL(uri string) bool {
+	return isHTTPSURLOnHost(uri, c.GistHosts)
+}
+
+// TwitterUser returns twitter handle without @, as used in twitter urls
+func (c *siteConfig) TwitterUser() string {
+	return strings.TrimPrefix(c.TwitterHandle, "@")
+}
+
+func (c *siteConfig) isIgnoredCollection(name string) bool {
+	for _, s := range c.IgnoredCollections {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseSiteConfig(d []byte) (*siteConfig, error) {
+	var c siteConfig
+	dec := json.NewDecoder(bytes.NewReader(d))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&c)
+	if err != nil {
+		return nil, err
+	}
+	// notion ids are often copied from urls, with dashes
+	c.NotionStartPage = normalizeID(c.NotionStartPage)
+	for i, id := range c.NotionRootPages {
+		c.NotionRootPages[i] = normalizeID(id)
+	}
+	err = c.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func loadSiteConfig(path string) (*siteConfig, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseSiteConfig(d)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid:\n%s", path, err)
+	}
+	return c, nil
+}
+
+func setSiteConfig(c *siteConfig) {
+	site = c
+	d, err := json.Marshal(c)
+	panicIfErr(err)
+	siteConfigHash = hashBytes(d)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSiteConfig(t *testing.T) {
+	d, err := ioutil.ReadFile("site.json")
+	assert.NoError(t, err)
+	c, err := parseSiteConfig(d)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://blog.kowalczyk.info", c.Host)
+	assert.True(t, c.isNotionRootPage("568ac4c0-64c3-4ef6-a6ad-0b8d77230681"))
+	assert.True(t, c.isNotionRootPage("300db9dc27c84958a08b8d0c37f4cfe5"))
+	assert.False(t, c.isNotionRootPage("0367c2db381a4f8b9ce360f388a6b2e3"))
+	assert.True(t, c.isIgnoredCollection("go-windows"))
+	assert.Equal(t, "Go Cookbook", c.Collections["go-cookbook"].Title)
+	assert.True(t, c.isAllowedGistURL("https://gist.github.com/kjk/1234"))
+	assert.Equal(t, "kjk", c.TwitterUser())
+}
+
+func TestSiteConfigInvalid(t *testing.T) {
+	tests := []struct {
+		s   string
+		err string
+	}{
+		{
+			`{"host": "https://example.com", "feed_title": "t", "notion_start_page": "568ac4c064c34ef6a6ad0b8d77230681", "hots": "x"}`,
+			`json: unknown field "hots"`,
+		},
+		{
+			`{}`,
+			"host is missing\nfeed_title is missing\nnotion_start_page is missing",
+		},
+		{
+			`{"host": "https://example.com/", "feed_title": "t", "notion_start_page": "568ac4c064"}`,
+			"host 'https://example.com/' should not have a path, not even a trailing slash\nnotion_start_page '568ac4c064' is not a valid notion id",
+		},
+		{
+			`{"host": "example.com", "feed_title": "t", "twitter_handle": "kjk", "notion_start_page": "568ac4c064c34ef6a6ad0b8d77230681",
+			  "collections": {"a": {"url": "/a.html"}, "b": {"title": "B", "url": "b.html"}}, "ignored_collections": ["b"]}`,
+			"host 'example.com' is not an http:// or https:// url\ntwitter_handle 'kjk' should start with @\ncollections: 'a' has no title\ncollections: url 'b.html' of 'b' should start with /\nignored_collections: 'b' is also in collections",
+		},
+		{
+			`{"host": "https://example.com", "feed_title": "t", "notion_start_page": "568ac4c064c34ef6a6ad0b8d77230681",
+			  "iframe_hosts": ["https://www.youtube.com"], "gist_hosts": ["gist.github.com/kjk"]}`,
+			"'https://www.youtube.com' in iframe_hosts or gist_hosts is not a host name like www.youtube.com\n'gist.github.com/kjk' in iframe_hosts or gist_hosts is not a host name like www.youtube.com",
+		},
+		{
+			`{"host": "https://example.com", "feed_title": "t", "notion_start_page": "568ac4c064c34ef6a6ad0b8d77230681",
+			  "headers": {"tools/*": {}, "/a*": {}, "/img/*": {"Cache Control": "no-cache", "X-Test": "a\nb"}}}`,
+			"headers: path '/a*' should start with / and can only end with /*\nheaders: 'Cache Control' of '/img/*' is not a valid header name\nheaders: value of 'X-Test' of '/img/*' has a new line\nheaders: path 'tools/*' should start with / and can only end with /*",
+		},
+	}
+	for _, test := range tests {
+		_, err := parseSiteConfig([]byte(test.s))
+		if assert.Error(t, err) {
+			assert.Equal(t, test.err, err.Error())
+		}
+	}
+}
@@ -18,6 +18,8 @@ var (
 	tmplGoCookBook       = "go-cookbook.tmpl.html"
 	tmplChangelog        = "changelog.tmpl.html"
 	tmpl404              = "404.tmpl.html"
+	tmplSearch           = "search.tmpl.html"
+	tmplAnalytics        = "analytics.tmpl.html"
 	templateNames        = []string{
 		tmplMainPage,
 		tmplBlogIndex,
@@ -27,7 +29,8 @@ var (
 		tmplGoCookBook,
 		tmplChangelog,
 		tmpl404,
-		"analytics.tmpl.html",
+		tmplSearch,
+		tmplAnalytics,
 		"page_navbar.tmpl.html",
 	}
 	templatePaths []string
@@ -54,22 +57,44 @@ func findTemplate(name string) string {
 }
 
 func loadTemplates() {
+	templatePaths = nil
+	var contents []string
 	for _, name := range templateNames {
 		path := findTemplate(name)
 		templatePaths = append(templatePaths, path)
+		d, err := ioutil.ReadFile(path)
+		panicIfErr(err)
+		contents = append(contents, string(d))
 	}
-	templates = template.Must(template.ParseFiles(templatePaths...))
-}
-
-func netlifyExecTemplate(fileName string, templateName string, model interface{}) {
-	path := netlifyPath(fileName)
-	execTemplate(path, templateName, model)
+	// templates can use {{site.Host}} etc.
+	funcs := template.FuncMap{
+		"site": func() *siteConfig { return site },
+	}
+	templates = template.Must(template.New("").Funcs(funcs).ParseFiles(templatePaths...))
+	templatesHash = hashStrings(contents...)
 }
 
-func execTemplate(path string, templateName string, model interface{}) {
+func execTemplateToBytes(templateName string, model interface{}) []byte {
 	var buf bytes.Buffer
 	err := templates.ExecuteTemplate(&buf, templateName, model)
 	panicIfErr(err)
-	err = ioutil.WriteFile(path, buf.Bytes(), 0644)
-	panicIfErr(err)
+	return buf.Bytes()
+}
+
+func netlifyExecTemplate(fileName string, templateName string, model interface{}) {
+	d := execTemplateToBytes(templateName, model)
+	netlifyWriteFile(fileName, d)
+}
+
+// netlifyExecTemplateIfChanged is like netlifyExecTemplate but doesn't
+// execute the template if the file was generated from the same inputs
+// in the previous build. inputHash must cover everything from the model
+// that is used by the template
+func netlifyExecTemplateIfChanged(fileName string, templateName string, inputHash string, model interface{}) {
+	inputHash = hashStrings(templatesHash, templateName, siteConfigHash, inputHash)
+	if netlifyFileUpToDate(fileName, inputHash) {
+		return
+	}
+	d := execTemplateToBytes(templateName, model)
+	netlifyWriteFileRaw(fileName, d)
 }
@@ -0,0 +1,703 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+// We render equations at build time to the same markup KaTeX generates
+// with output: "mathml". Browsers render MathML natively so equations
+// don't need JavaScript and also show up in feeds. We support the subset
+// of TeX commonly used in articles. Equations we can't convert are shown
+// as TeX source, like KaTeX does on errors.
+
+const mathMLNamespace = "http://www.w3.org/1998/Math/MathML"
+
+var (
+	// letters rendered in italic
+	texIdentifiers = map[string]string{
+		`\alpha`: "α", `\beta`: "β", `\gamma`: "γ", `\delta`: "δ",
+		`\epsilon`: "ϵ", `\varepsilon`: "ε", `\zeta`: "ζ", `\eta`: "η",
+		`\theta`: "θ", `\vartheta`: "ϑ", `\iota`: "ι", `\kappa`: "κ",
+		`\lambda`: "λ", `\mu`: "μ", `\nu`: "ν", `\xi`: "ξ", `\pi`: "π",
+		`\varpi`: "ϖ", `\rho`: "ρ", `\varrho`: "ϱ", `\sigma`: "σ",
+		`\varsigma`: "ς", `\tau`: "τ", `\upsilon`: "υ", `\phi`: "ϕ",
+		`\varphi`: "φ", `\chi`: "χ", `\psi`: "ψ", `\omega`: "ω",
+		`\ell`: "ℓ", `\hbar`: "ℏ", `\partial`: "∂", `\imath`: "ı", `\jmath`: "ȷ",
+	}
+	// letters and symbols rendered upright
+	texUprightIdentifiers = map[string]string{
+		`\Gamma`: "Γ", `\Delta`: "Δ", `\Theta`: "Θ", `\Lambda`: "Λ",
+		`\Xi`: "Ξ", `\Pi`: "Π", `\Sigma`: "Σ", `\Upsilon`: "Υ",
+		`\Phi`: "Φ", `\Psi`: "Ψ", `\Omega`: "Ω",
+		`\infty`: "∞", `\nabla`: "∇", `\emptyset`: "∅", `\varnothing`: "∅",
+		`\aleph`: "ℵ", `\Re`: "ℜ", `\Im`: "ℑ", `\%`: "%", `\$`: "$",
+		`\#`: "#", `\&`: "&", `\_`: "_",
+	}
+	texOperators = map[string]string{
+		`\times`: "×", `\cdot`: "⋅", `\div`: "÷", `\pm`: "±", `\mp`: "∓",
+		`\ast`: "∗", `\star`: "⋆", `\circ`: "∘", `\bullet`: "∙",
+		`\oplus`: "⊕", `\otimes`: "⊗", `\setminus`: "∖",
+		`\cup`: "∪", `\cap`: "∩", `\wedge`: "∧", `\land`: "∧",
+		`\vee`: "∨", `\lor`: "∨", `\neg`: "¬", `\lnot`: "¬",
+		`\leq`: "≤", `\le`: "≤", `\geq`: "≥", `\ge`: "≥", `\neq`: "≠",
+		`\ne`: "≠", `\ll`: "≪", `\gg`: "≫", `\approx`: "≈", `\equiv`: "≡",
+		`\sim`: "∼", `\simeq`: "≃", `\cong`: "≅", `\propto`: "∝",
+		`\in`: "∈", `\notin`: "∉", `\ni`: "∋", `\subset`: "⊂",
+		`\subseteq`: "⊆", `\supset`: "⊃", `\supseteq`: "⊇",
+		`\forall`: "∀", `\exists`: "∃", `\perp`: "⊥", `\parallel`: "∥",
+		`\mid`: "∣", `\angle`: "∠", `\triangle`: "△",
+		`\to`: "→", `\rightarrow`: "→", `\leftarrow`: "←", `\gets`: "←",
+		`\leftrightarrow`: "↔", `\Rightarrow`: "⇒", `\Leftarrow`: "⇐",
+		`\Leftrightarrow`: "⇔", `\implies`: "⟹", `\iff`: "⟺",
+		`\mapsto`: "↦", `\uparrow`: "↑", `\downarrow`: "↓",
+		`\ldots`: "…", `\dots`: "…", `\cdots`: "⋯", `\vdots`: "⋮",
+		`\ddots`: "⋱", `\prime`: "′", `\colon`: ":",
+		`\{`: "{", `\}`: "}", `\|`: "‖",
+		`\langle`: "⟨", `\rangle`: "⟩", `\lfloor`: "⌊", `\rfloor`: "⌋",
+		`\lceil`: "⌈", `\rceil`: "⌉", `\vert`: "|", `\Vert`: "‖",
+		`\lvert`: "|", `\rvert`: "|", `\lVert`: "‖", `\rVert`: "‖",
+		`\backslash`: "\\",
+	}
+	// the bool is true if limits go below and above in display mode
+	texLargeOperators = map[string]struct {
+		s      string
+		limits bool
+	}{
+		`\sum`: {"∑", true}, `\prod`: {"∏", true}, `\coprod`: {"∐", true},
+		`\bigcup`: {"⋃", true}, `\bigcap`: {"⋂", true},
+		`\bigoplus`: {"⨁", true}, `\bigotimes`: {"⨂", true},
+		`\int`: {"∫", false}, `\iint`: {"∬", false}, `\iiint`: {"∭", false},
+		`\oint`: {"∮", false},
+	}
+	// the bool is true if limits go below and above in display mode
+	texFunctions = map[string]bool{
+		`\sin`: false, `\cos`: false, `\tan`: false, `\cot`: false,
+		`\sec`: false, `\csc`: false, `\arcsin`: false, `\arccos`: false,
+		`\arctan`: false, `\sinh`: false, `\cosh`: false, `\tanh`: false,
+		`\log`: false, `\ln`: false, `\lg`: false, `\exp`: false,
+		`\dim`: false, `\ker`: false, `\deg`: false, `\hom`: false,
+		`\arg`: false, `\det`: true, `\gcd`: true, `\Pr`: true,
+		`\lim`: true, `\liminf`: true, `\limsup`: true, `\max`: true,
+		`\min`: true, `\sup`: true, `\inf`: true,
+	}
+	texFonts = map[string]string{
+		`\mathrm`: "normal", `\mathbf`: "bold", `\mathit`: "italic",
+		`\mathbb`: "double-struck", `\mathcal`: "script",
+		`\mathfrak`: "fraktur", `\mathsf`: "sans-serif",
+		`\mathtt`: "monospace", `\boldsymbol`: "bold-italic",
+		`\bm`: "bold-italic",
+	}
+	texAccents = map[string]struct {
+		s       string
+		stretch bool
+	}{
+		`\hat`: {"^", false}, `\widehat`: {"^", true}, `\bar`: {"ˉ", false},
+		`\overline`: {"‾", true}, `\vec`: {"⃗", false},
+		`\overrightarrow`: {"→", true}, `\tilde`: {"~", false},
+		`\widetilde`: {"~", true}, `\dot`: {"˙", false},
+		`\ddot`: {"¨", false}, `\check`: {"ˇ", false},
+		`\breve`: {"˘", false}, `\acute`: {"ˊ", false},
+		`\grave`: {"ˋ", false},
+	}
+	texSpaces = map[string]string{
+		`\,`: "0.1667em", `\:`: "0.2222em", `\>`: "0.2222em",
+		`\;`: "0.2778em", `\ `: "0.3333em", `~`: "0.3333em",
+		`\quad`: "1em", `\qquad`: "2em", `\!`: "-0.1667em",
+	}
+	texTextCommands = map[string]bool{
+		`\text`: true, `\textrm`: true, `\textit`: true, `\textbf`: true,
+		`\mbox`: true,
+	}
+	texBigSizes = map[string]string{
+		`\big`: "1.2em", `\bigl`: "1.2em", `\bigr`: "1.2em", `\bigm`: "1.2em",
+		`\Big`: "1.8em", `\Bigl`: "1.8em", `\Bigr`: "1.8em", `\Bigm`: "1.8em",
+		`\bigg`: "2.4em", `\biggl`: "2.4em", `\biggr`: "2.4em", `\biggm`: "2.4em",
+		`\Bigg`: "3em", `\Biggl`: "3em", `\Biggr`: "3em", `\Biggm`: "3em",
+	}
+	// delimiters around matrix environments
+	texMatrixFences = map[string][2]string{
+		"matrix":  {"", ""},
+		"pmatrix": {"(", ")"},
+		"bmatrix": {"[", "]"},
+		"Bmatrix": {"{", "}"},
+		"vmatrix": {"|", "|"},
+		"Vmatrix": {"‖", "‖"},
+	}
+	texOperatorChars = "+-=<>/*,;:!?|()[].'"
+)
+
+type texParser struct {
+	s       string
+	pos     int
+	display bool
+	// mathvariant of letters e.g. "bold" inside \mathbf{}
+	variant string
+}
+
+// texAtom is a node that can have sub and superscripts
+type texAtom struct {
+	node string
+	// if true, scripts go below and above in display mode
+	limits bool
+	// functions like \sin are followed by invisible function application
+	isFunction bool
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (p *texParser) skipSpaces() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) != -1 {
+		p.pos++
+	}
+}
+
+// next returns the next token: a command like \frac or a single character.
+// Returns "" at the end
+func (p *texParser) next() string {
+	p.skipSpaces()
+	if p.pos >= len(p.s) {
+		return ""
+	}
+	start := p.pos
+	if p.s[p.pos] == '\\' {
+		p.pos++
+		if p.pos >= len(p.s) {
+			return `\`
+		}
+		if !isASCIILetter(p.s[p.pos]) {
+			// \, \{ \\ etc.
+			_, size := utf8.DecodeRuneInString(p.s[p.pos:])
+			p.pos += size
+			return p.s[start:p.pos]
+		}
+		for p.pos < len(p.s) && isASCIILetter(p.s[p.pos]) {
+			p.pos++
+		}
+		return p.s[start:p.pos]
+	}
+	_, size := utf8.DecodeRuneInString(p.s[p.pos:])
+	p.pos += size
+	return p.s[start:p.pos]
+}
+
+func (p *texParser) peek() string {
+	pos := p.pos
+	tok := p.next()
+	p.pos = pos
+	return tok
+}
+
+func (p *texParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		if got == "" {
+			return fmt.Errorf("expected '%s' at the end", tok)
+		}
+		return fmt.Errorf("expected '%s', got '%s'", tok, got)
+	}
+	return nil
+}
+
+// readRaw reads content of {} group without parsing it, e.g. for \text{}
+func (p *texParser) readRaw() (string, error) {
+	if err := p.expect("{"); err != nil {
+		return "", err
+	}
+	start := p.pos
+	depth := 1
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case '\\':
+			p.pos++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				s := p.s[start:p.pos]
+				p.pos++
+				return s, nil
+			}
+		}
+		p.pos++
+	}
+	return "", fmt.Errorf("missing '}'")
+}
+
+func mrow(nodes []string) string {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return "<mrow>" + strings.Join(nodes, "") + "</mrow>"
+}
+
+func mathElement(name string, s string) string {
+	return "<" + name + ">" + html.EscapeString(s) + "</" + name + ">"
+}
+
+func (p *texParser) mi(s string) string {
+	if p.variant != "" {
+		return fmt.Sprintf(`<mi mathvariant="%s">%s</mi>`, p.variant, html.EscapeString(s))
+	}
+	return mathElement("mi", s)
+}
+
+// parseList parses nodes until the end or }, &, \\, \end, \right or ]
+// (if inOptional). It doesn't consume the terminator
+func (p *texParser) parseList(inOptional bool) ([]string, error) {
+	var nodes []string
+	for {
+		tok := p.peek()
+		switch tok {
+		case "", "}", "&", `\\`, `\end`, `\right`:
+			return nodes, nil
+		case "]":
+			if inOptional {
+				return nodes, nil
+			}
+		case `\displaystyle`, `\textstyle`:
+			p.next()
+			rest, err := p.parseList(inOptional)
+			if err != nil {
+				return nil, err
+			}
+			node := fmt.Sprintf(`<mstyle displaystyle="%t" scriptlevel="0">%s</mstyle>`, tok == `\displaystyle`, mrow(rest))
+			return append(nodes, node), nil
+		}
+		node, err := p.parseScripted()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+// parseArg parses argument of a command or a script: a group or a single atom
+func (p *texParser) parseArg() (string, error) {
+	if p.peek() == "" {
+		return "", fmt.Errorf("missing argument")
+	}
+	atom, err := p.parseAtom()
+	if err != nil {
+		return "", err
+	}
+	return atom.node, nil
+}
+
+// parseScripted parses an atom with optional sub and superscripts
+func (p *texParser) parseScripted() (string, error) {
+	atom, err := p.parseAtom()
+	if err != nil {
+		return "", err
+	}
+	limits := atom.limits && p.display
+	var sub, sup []string
+	hasSub, hasSup := false, false
+	for {
+		tok := p.peek()
+		if tok == "^" || tok == "_" {
+			p.next()
+			arg, err := p.parseArg()
+			if err != nil {
+				return "", err
+			}
+			if tok == "_" {
+				if hasSub {
+					return "", fmt.Errorf("double subscript")
+				}
+				hasSub = true
+				sub = append(sub, arg)
+			} else {
+				if hasSup {
+					return "", fmt.Errorf("double superscript")
+				}
+				hasSup = true
+				sup = append(sup, arg)
+			}
+			continue
+		}
+		if tok == "'" {
+			p.next()
+			sup = append(sup, "<mo>′</mo>")
+			continue
+		}
+		if tok == `\limits` || tok == `\nolimits` {
+			p.next()
+			limits = tok == `\limits`
+			continue
+		}
+		break
+	}
+	node := atom.node
+	switch {
+	case len(sub) > 0 && len(sup) > 0 && limits:
+		node = "<munderover>" + node + mrow(sub) + mrow(sup) + "</munderover>"
+	case len(sub) > 0 && len(sup) > 0:
+		node = "<msubsup>" + node + mrow(sub) + mrow(sup) + "</msubsup>"
+	case len(sub) > 0 && limits:
+		node = "<munder>" + node + mrow(sub) + "</munder>"
+	case len(sub) > 0:
+		node = "<msub>" + node + mrow(sub) + "</msub>"
+	case len(sup) > 0 && limits:
+		node = "<mover>" + node + mrow(sup) + "</mover>"
+	case len(sup) > 0:
+		node = "<msup>" + node + mrow(sup) + "</msup>"
+	}
+	if atom.isFunction {
+		node += "<mo>&#x2061;</mo>"
+	}
+	return node, nil
+}
+
+// parseDelimiter parses delimiter after \left, \right, \big etc.
+// Returns "" for . (no delimiter)
+func (p *texParser) parseDelimiter() (string, error) {
+	tok := p.next()
+	switch {
+	case tok == ".":
+		return "", nil
+	case tok == "<":
+		return "⟨", nil
+	case tok == ">":
+		return "⟩", nil
+	case len(tok) == 1 && strings.Contains("()[]|/", tok):
+		return tok, nil
+	case texOperators[tok] != "":
+		return texOperators[tok], nil
+	}
+	return "", fmt.Errorf("'%s' is not a delimiter", tok)
+}
+
+func fence(s string) string {
+	if s == "" {
+		return ""
+	}
+	return `<mo fence="true">` + html.EscapeString(s) + "</mo>"
+}
+
+func (p *texParser) parseAtom() (*texAtom, error) {
+	tok := p.next()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end")
+	case "{":
+		nodes, err := p.parseList(false)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect("}"); err != nil {
+			return nil, err
+		}
+		return &texAtom{node: mrow(append([]string{}, nodes...))}, nil
+	case "^", "_":
+		// script without a base
+		p.pos--
+		return &texAtom{node: "<mrow></mrow>"}, nil
+	case "}", "&", "$", "#", "%", `\\`:
+		return nil, fmt.Errorf("unexpected '%s'", tok)
+	case "-":
+		return &texAtom{node: "<mo>−</mo>"}, nil
+	case "*":
+		return &texAtom{node: "<mo>∗</mo>"}, nil
+	case "'":
+		return &texAtom{node: "<mo>′</mo>"}, nil
+	}
+	if texSpaces[tok] != "" {
+		return &texAtom{node: fmt.Sprintf(`<mspace width="%s"></mspace>`, texSpaces[tok])}, nil
+	}
+	if isDigit(tok[0]) {
+		// 3.14 is a single number
+		for p.pos < len(p.s) {
+			c := p.s[p.pos]
+			if isDigit(c) || (c == '.' && p.pos+1 < len(p.s) && isDigit(p.s[p.pos+1])) {
+				tok += string(c)
+				p.pos++
+				continue
+			}
+			break
+		}
+		return &texAtom{node: mathElement("mn", tok)}, nil
+	}
+	if tok[0] != '\\' {
+		if strings.Contains(texOperatorChars, tok) {
+			return &texAtom{node: mathElement("mo", tok)}, nil
+		}
+		return &texAtom{node: p.mi(tok)}, nil
+	}
+	return p.parseCommand(tok)
+}
+
+func (p *texParser) parseCommand(cmd string) (*texAtom, error) {
+	if s := texIdentifiers[cmd]; s != "" {
+		return &texAtom{node: p.mi(s)}, nil
+	}
+	if s := texUprightIdentifiers[cmd]; s != "" {
+		return &texAtom{node: fmt.Sprintf(`<mi mathvariant="normal">%s</mi>`, html.EscapeString(s))}, nil
+	}
+	if s := texOperators[cmd]; s != "" {
+		return &texAtom{node: mathElement("mo", s)}, nil
+	}
+	if op, ok := texLargeOperators[cmd]; ok {
+		return &texAtom{node: mathElement("mo", op.s), limits: op.limits}, nil
+	}
+	if limits, ok := texFunctions[cmd]; ok {
+		node := fmt.Sprintf(`<mi mathvariant="normal">%s</mi>`, cmd[1:])
+		return &texAtom{node: node, limits: limits, isFunction: true}, nil
+	}
+	if size := texBigSizes[cmd]; size != "" {
+		d, err := p.parseDelimiter()
+		if err != nil {
+			return nil, err
+		}
+		node := fmt.Sprintf(`<mo fence="false" stretchy="true" minsize="%s" maxsize="%s">%s</mo>`, size, size, html.EscapeString(d))
+		return &texAtom{node: node}, nil
+	}
+	if variant := texFonts[cmd]; variant != "" {
+		prev := p.variant
+		p.variant = variant
+		node, err := p.parseArg()
+		p.variant = prev
+		if err != nil {
+			return nil, err
+		}
+		return &texAtom{node: node}, nil
+	}
+	if texTextCommands[cmd] {
+		s, err := p.readRaw()
+		if err != nil {
+			return nil, err
+		}
+		return &texAtom{node: mathElement("mtext", s)}, nil
+	}
+	if accent, ok := texAccents[cmd]; ok {
+		base, err := p.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		mo := mathElement("mo", accent.s)
+		if accent.stretch {
+			mo = fmt.Sprintf(`<mo stretchy="true">%s</mo>`, html.EscapeString(accent.s))
+		}
+		return &texAtom{node: `<mover accent="true">` + base + mo + "</mover>"}, nil
+	}
+
+	switch cmd {
+	case `\frac`, `\dfrac`, `\tfrac`, `\cfrac`, `\binom`:
+		num, err := p.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		denom, err := p.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		if cmd == `\binom` {
+			node := `<mrow><mo fence="true">(</mo><mfrac linethickness="0px">` + num + denom + `</mfrac><mo fence="true">)</mo></mrow>`
+			return &texAtom{node: node}, nil
+		}
+		return &texAtom{node: "<mfrac>" + num + denom + "</mfrac>"}, nil
+	case `\sqrt`:
+		var index []string
+		if p.peek() == "[" {
+			p.next()
+			var err error
+			if index, err = p.parseList(true); err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		if len(index) > 0 {
+			return &texAtom{node: "<mroot>" + arg + mrow(index) + "</mroot>"}, nil
+		}
+		return &texAtom{node: "<msqrt>" + arg + "</msqrt>"}, nil
+	case `\underline`:
+		base, err := p.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		return &texAtom{node: `<munder accentunder="true">` + base + `<mo stretchy="true">‾</mo></munder>`}, nil
+	case `\overset`, `\stackrel`, `\underset`:
+		over, err := p.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		base, err := p.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		if cmd == `\underset` {
+			return &texAtom{node: "<munder>" + base + over + "</munder>"}, nil
+		}
+		return &texAtom{node: "<mover>" + base + over + "</mover>"}, nil
+	case `\operatorname`, `\operatorname*`:
+		name, err := p.readRaw()
+		if err != nil {
+			return nil, err
+		}
+		node := fmt.Sprintf(`<mi mathvariant="normal">%s</mi>`, html.EscapeString(name))
+		return &texAtom{node: node, isFunction: true}, nil
+	case `\not`:
+		tok := p.next()
+		s := texOperators[tok]
+		if s == "" && len(tok) == 1 && strings.Contains("=<>", tok) {
+			s = tok
+		}
+		if s == "" {
+			return nil, fmt.Errorf("can't negate '%s'", tok)
+		}
+		// combining long solidus overlay
+		return &texAtom{node: mathElement("mo", s+"̸")}, nil
+	case `\left`:
+		open, err := p.parseDelimiter()
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := p.parseList(false)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(`\right`); err != nil {
+			return nil, err
+		}
+		close, err := p.parseDelimiter()
+		if err != nil {
+			return nil, err
+		}
+		return &texAtom{node: "<mrow>" + fence(open) + strings.Join(nodes, "") + fence(close) + "</mrow>"}, nil
+	case `\middle`:
+		d, err := p.parseDelimiter()
+		if err != nil {
+			return nil, err
+		}
+		return &texAtom{node: fence(d)}, nil
+	case `\begin`:
+		return p.parseEnvironment()
+	}
+	return nil, fmt.Errorf("unsupported command %s", cmd)
+}
+
+// parseEnvironment parses \begin{name} ... \end{name} with rows separated
+// by \\ and cells by &
+func (p *texParser) parseEnvironment() (*texAtom, error) {
+	name, err := p.readRaw()
+	if err != nil {
+		return nil, err
+	}
+	fences, isMatrix := texMatrixFences[name]
+	columnAlign := "center"
+	switch {
+	case isMatrix:
+	case name == "cases":
+		fences = [2]string{"{", ""}
+		columnAlign = "left left"
+	case name == "aligned", name == "align", name == "align*":
+		columnAlign = "right left"
+	case name == "array":
+		// we don't support column spec, all columns are centered
+		if _, err = p.readRaw(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported environment %s", name)
+	}
+
+	var rows []string
+	var cells []string
+	for {
+		nodes, err := p.parseList(false)
+		if err != nil {
+			return nil, err
+		}
+		cells = append(cells, "<mtd>"+mrow(nodes)+"</mtd>")
+		tok := p.next()
+		if tok == "&" {
+			continue
+		}
+		rows = append(rows, "<mtr>"+strings.Join(cells, "")+"</mtr>")
+		cells = nil
+		if tok == `\\` {
+			if p.peek() == `\end` {
+				// trailing \\
+				continue
+			}
+			continue
+		}
+		if tok != `\end` {
+			return nil, fmt.Errorf("missing \\end{%s}", name)
+		}
+		end, err := p.readRaw()
+		if err != nil {
+			return nil, err
+		}
+		if end != name {
+			return nil, fmt.Errorf("\\begin{%s} ended with \\end{%s}", name, end)
+		}
+		break
+	}
+	table := fmt.Sprintf(`<mtable rowspacing="0.25em" columnalign="%s">%s</mtable>`, columnAlign, strings.Join(rows, ""))
+	if fences[0] == "" && fences[1] == "" {
+		return &texAtom{node: table}, nil
+	}
+	return &texAtom{node: "<mrow>" + fence(fences[0]) + table + fence(fences[1]) + "</mrow>"}, nil
+}
+
+// texToMathML converts TeX to MathML. In display mode limits of operators
+// like \sum go below and above
+func texToMathML(tex string, display bool) (string, error) {
+	p := &texParser{
+		s:       tex,
+		display: display,
+	}
+	var nodes []string
+	for {
+		list, err := p.parseList(false)
+		if err != nil {
+			return "", err
+		}
+		nodes = append(nodes, list...)
+		tok := p.next()
+		if tok == "" {
+			break
+		}
+		if tok != `\\` {
+			return "", fmt.Errorf("unexpected '%s'", tok)
+		}
+		nodes = append(nodes, `<mspace linebreak="newline"></mspace>`)
+	}
+	attr := ""
+	if display {
+		attr = ` display="block"`
+	}
+	s := fmt.Sprintf(`<math xmlns="%s"%s><semantics><mrow>%s</mrow><annotation encoding="application/x-tex">%s</annotation></semantics></math>`, mathMLNamespace, attr, strings.Join(nodes, ""), html.EscapeString(tex))
+	return s, nil
+}
+
+// equationHTML returns html for TeX equation, the same as KaTeX generates.
+// If we can't convert it, returns error and html showing the TeX source
+func equationHTML(tex string, display bool) (string, error) {
+	tex = strings.TrimSpace(tex)
+	math, err := texToMathML(tex, display)
+	if err != nil {
+		s := fmt.Sprintf(`<span class="katex-error" title="%s">%s</span>`, html.EscapeString(err.Error()), html.EscapeString(tex))
+		return s, err
+	}
+	s := `<span class="katex">` + math + `</span>`
+	if display {
+		s = `<span class="katex-display">` + s + `</span>`
+	}
+	return s, nil
+}
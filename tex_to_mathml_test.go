@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustEquationHTML(tex string, display bool) string {
+	s, err := equationHTML(tex, display)
+	panicIfErr(err)
+	return s
+}
+
+func TestTexToMathML(t *testing.T) {
+	tests := []struct {
+		tex     string
+		display bool
+		exp     string
+	}{
+		{`x^2 + 3.14`, false, `<msup><mi>x</mi><mn>2</mn></msup><mo>+</mo><mn>3.14</mn>`},
+		{`a_{ij}^{-1}`, false, `<msubsup><mi>a</mi><mrow><mi>i</mi><mi>j</mi></mrow><mrow><mo>−</mo><mn>1</mn></mrow></msubsup>`},
+		{`f'(x)`, false, `<msup><mi>f</mi><mo>′</mo></msup><mo>(</mo><mi>x</mi><mo>)</mo>`},
+		{`\frac{1}{2}`, false, `<mfrac><mn>1</mn><mn>2</mn></mfrac>`},
+		{`\sqrt[n]{x}`, false, `<mroot><mi>x</mi><mi>n</mi></mroot>`},
+		{`\alpha \Gamma \infty`, false, `<mi>α</mi><mi mathvariant="normal">Γ</mi><mi mathvariant="normal">∞</mi>`},
+		{`\sum_{i=0}^n i`, false, `<msubsup><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>0</mn></mrow><mi>n</mi></msubsup><mi>i</mi>`},
+		{`\sum_{i=0}^n i`, true, `<munderover><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>0</mn></mrow><mi>n</mi></munderover><mi>i</mi>`},
+		{`\int\limits_0^1`, false, `<munderover><mo>∫</mo><mn>0</mn><mn>1</mn></munderover>`},
+		{`\lim_{x \to 0} \sin x`, true, `<munder><mi mathvariant="normal">lim</mi><mrow><mi>x</mi><mo>→</mo><mn>0</mn></mrow></munder><mo>&#x2061;</mo><mi mathvariant="normal">sin</mi><mo>&#x2061;</mo><mi>x</mi>`},
+		{`\mathbf{v} \text{ if } a<b`, false, `<mi mathvariant="bold">v</mi><mtext> if </mtext><mi>a</mi><mo>&lt;</mo><mi>b</mi>`},
+		{`\left( x \middle| y \right.`, false, `<mrow><mo fence="true">(</mo><mi>x</mi><mo fence="true">|</mo><mi>y</mi></mrow>`},
+		{`\vec{v} \not= 0`, false, `<mover accent="true"><mi>v</mi><mo>⃗</mo></mover><mo>≠</mo><mn>0</mn>`},
+		{`a \quad b`, false, `<mi>a</mi><mspace width="1em"></mspace><mi>b</mi>`},
+		{
+			`\begin{cases} 1 & x > 0 \\ 0 & \text{otherwise} \end{cases}`, false,
+			`<mrow><mo fence="true">{</mo><mtable rowspacing="0.25em" columnalign="left left"><mtr><mtd><mn>1</mn></mtd><mtd><mrow><mi>x</mi><mo>&gt;</mo><mn>0</mn></mrow></mtd></mtr><mtr><mtd><mn>0</mn></mtd><mtd><mtext>otherwise</mtext></mtd></mtr></mtable></mrow>`,
+		},
+		{`a \\ b`, true, `<mi>a</mi><mspace linebreak="newline"></mspace><mi>b</mi>`},
+	}
+	for _, test := range tests {
+		got, err := texToMathML(test.tex, test.display)
+		assert.NoError(t, err, "tex: %s", test.tex)
+		display := ""
+		if test.display {
+			display = ` display="block"`
+		}
+		exp := `<math xmlns="http://www.w3.org/1998/Math/MathML"` + display + `><semantics><mrow>` + test.exp + `</mrow><annotation encoding="application/x-tex">`
+		assert.Contains(t, got, exp, "tex: %s", test.tex)
+	}
+}
+
+func TestTexToMathMLErrors(t *testing.T) {
+	tests := []struct {
+		tex string
+		err string
+	}{
+		{`\frac{1}`, "missing argument"},
+		{`{x`, "expected '}' at the end"},
+		{`x}`, "unexpected '}'"},
+		{`\foo`, `unsupported command \foo`},
+		{`a^1^2`, "double superscript"},
+		{`\begin{pmatrix} a \end{bmatrix}`, `\begin{pmatrix} ended with \end{bmatrix}`},
+		{`\left( x`, `expected '\right' at the end`},
+	}
+	for _, test := range tests {
+		_, err := texToMathML(test.tex, false)
+		assert.EqualError(t, err, test.err, "tex: %s", test.tex)
+	}
+}
+
+func TestEquationHTML(t *testing.T) {
+	s, err := equationHTML(" x<y ", false)
+	assert.NoError(t, err)
+	assert.Equal(t, `<span class="katex"><math xmlns="http://www.w3.org/1998/Math/MathML"><semantics><mrow><mi>x</mi><mo>&lt;</mo><mi>y</mi></mrow><annotation encoding="application/x-tex">x&lt;y</annotation></semantics></math></span>`, s)
+
+	s, err = equationHTML(`\foo<`, true)
+	assert.Error(t, err)
+	assert.Equal(t, `<span class="katex-error" title="unsupported command \foo">\foo&lt;</span>`, s)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// Headings in articles get ids (slugs made from their text) so that they
+// can be linked to. They're also collected into a table of contents, which
+// is shown if an article has "toc: yes" in metadata or has at least
+// tocMinHeadings headings
+
+const tocMinHeadings = 5
+
+// tocHeading is a heading in article's table of contents
+type tocHeading struct {
+	ID    string
+	Title string
+	// 1 for h1, 2 for h2 etc.
+	Level int
+}
+
+// headingSlugs generates unique ids for headings of a single article
+type headingSlugs struct {
+	used map[string]bool
+}
+
+func newHeadingSlugs() *headingSlugs {
+	return &headingSlugs{
+		used: map[string]bool{},
+	}
+}
+
+// slug returns id for a heading with a given title. If the title was
+// already used, the id gets a -1, -2 etc. suffix
+func (s *headingSlugs) slug(title string) string {
+	id := urlify(title)
+	if id == "" {
+		id = "section"
+	}
+	// toggle-* ids are used by toggles
+	if strings.HasPrefix(id, "toggle-") {
+		id = "h-" + id
+	}
+	res := id
+	for n := 1; s.used[res]; n++ {
+		res = fmt.Sprintf("%s-%d", id, n)
+	}
+	s.used[res] = true
+	return res
+}
+
+// headingPermalink is a link to the heading, shown when hovering over it
+func headingPermalink(id string) string {
+	return fmt.Sprintf(`<a class="hdr-permalink" href="#%s" aria-label="permalink">#</a>`, id)
+}
+
+// tocHTML renders table of contents of a page with table of contents block
+func tocHTML(headings []tocHeading) string {
+	s := `<div class="toc"><ul>`
+	for _, h := range headings {
+		s += fmt.Sprintf(`<li class="toc-lvl%d"><a href="#%s">%s</a></li>`, h.Level, h.ID, html.EscapeString(h.Title))
+	}
+	return s + `</ul></div>`
+}
+
+// ShowTOC returns true if table of contents should be shown for the article.
+// It's not shown if the author placed it in the body
+func (a *Article) ShowTOC() bool {
+	if a.tocInBody {
+		return false
+	}
+	switch a.tocMode {
+	case "yes":
+		return len(a.TOC) > 0
+	case "no":
+		return false
+	}
+	return len(a.TOC) >= tocMinHeadings
+}
+
+// markdownHeadingText returns text of a heading, without formatting
+func markdownHeadingText(hdr *ast.Heading) string {
+	var parts []string
+	ast.WalkFunc(hdr, func(node ast.Node, entering bool) ast.WalkStatus {
+		if leaf := node.AsLeaf(); entering && leaf != nil {
+			parts = append(parts, string(leaf.Literal))
+		}
+		return ast.GoToNext
+	})
+	return strings.TrimSpace(strings.Join(parts, ""))
+}
+
+// markdownSetHeadingIDs sets ids of all headings in doc and returns
+// them as table of contents
+func markdownSetHeadingIDs(doc ast.Node) []tocHeading {
+	var res []tocHeading
+	slugs := newHeadingSlugs()
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		hdr, ok := node.(*ast.Heading)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		title := markdownHeadingText(hdr)
+		hdr.HeadingID = slugs.slug(title)
+		h := tocHeading{
+			ID:    hdr.HeadingID,
+			Title: title,
+			Level: hdr.Level,
+		}
+		res = append(res, h)
+		return ast.SkipChildren
+	})
+	return res
+}
+
+// renderHeadingWithPermalink renders a markdown heading that has an id
+func renderHeadingWithPermalink(w io.Writer, hdr *ast.Heading, entering bool) {
+	if entering {
+		fmt.Fprintf(w, "\n<h%d id=\"%s\">", hdr.Level, hdr.HeadingID)
+		return
+	}
+	fmt.Fprintf(w, "%s</h%d>\n", headingPermalink(hdr.HeadingID), hdr.Level)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gomarkdown/markdown"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeadingSlugs(t *testing.T) {
+	slugs := newHeadingSlugs()
+	tests := []struct {
+		title string
+		exp   string
+	}{
+		{"Getting started", "getting-started"},
+		{"Getting started", "getting-started-1"},
+		{"Getting started 1", "getting-started-1-1"},
+		{"Getting started", "getting-started-2"},
+		{"9. Conclusion", "9.-conclusion"},
+		{"toggle content 3", "h-toggle-content-3"},
+		{"???", "section"},
+		{"", "section-1"},
+	}
+	for _, test := range tests {
+		got := slugs.slug(test.title)
+		assert.Equal(t, test.exp, got, "title: '%s'", test.title)
+	}
+}
+
+func TestMarkdownHeadings(t *testing.T) {
+	md := "# Intro\n\ntext\n\n## Using `go vet`\n\n## Intro\n"
+	doc := markdown.Parse([]byte(md), newMarkdownParser())
+	toc := markdownSetHeadingIDs(doc)
+	exp := []tocHeading{
+		{ID: "intro", Title: "Intro", Level: 1},
+		{ID: "using-go-vet", Title: "Using go vet", Level: 2},
+		{ID: "intro-1", Title: "Intro", Level: 2},
+	}
+	assert.Equal(t, exp, toc)
+	s := sanitizeHTML(markdown.Render(doc, newMarkdownHTMLRenderer("")))
+	assert.True(t, strings.Contains(s, `<h2 id="using-go-vet">Using <code>go vet</code><a class="hdr-permalink" href="#using-go-vet"`), s)
+
+	a := &Article{TOC: toc}
+	assert.False(t, a.ShowTOC())
+	a.tocMode = "yes"
+	assert.True(t, a.ShowTOC())
+}
@@ -305,6 +305,15 @@ func mkdirForFile(filePath string) error {
 	return os.MkdirAll(dir, 0755)
 }
 
+// writeFileIfChanged writes d to path unless the file already has this content
+func writeFileIfChanged(path string, d []byte) error {
+	existing, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(existing, d) {
+		return nil
+	}
+	return ioutil.WriteFile(path, d, 0644)
+}
+
 func copyFile(dst string, src string) error {
 	err := mkdirForFile(dst)
 	if err != nil {
@@ -372,3 +381,46 @@ func prettyHTML(d []byte) []byte {
 	s = gohtml.Format(s)
 	return []byte(s)
 }
+
+// formatSize returns human-readable size, like "1.5 MB"
+func formatSize(n int64) string {
+	const kb = 1024
+	switch {
+	case n >= kb*kb:
+		return fmt.Sprintf("%.1f MB", float64(n)/(kb*kb))
+	case n >= kb:
+		return fmt.Sprintf("%.1f kB", float64(n)/kb)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// writeFileAtomic writes d to a temporary file and renames it to path so
+// that path never has partially written content
+func writeFileAtomic(path string, d []byte) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	_, err = f.Write(d)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
@@ -135,3 +135,9 @@ func TestExtractNotionIDFromURL(t *testing.T) {
 		assert.Equal(t, test.exp, got)
 	}
 }
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "12 bytes", formatSize(12))
+	assert.Equal(t, "1.5 kB", formatSize(1536))
+	assert.Equal(t, "2.0 MB", formatSize(2*1024*1024))
+}
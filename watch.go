@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kjk/notionapi"
+)
+
+// In -watch mode we poll www, articles and notion_cache for changes and
+// re-run only the parts of rebuildAll that depend on changed files.
+// Errors (e.g. a typo in a template) are printed and we keep watching.
+
+var (
+	watchDirs     = []string{"www", mdArticlesDir, cacheDir}
+	watchInterval = time.Second
+)
+
+// rebuildSteps says which parts of rebuildAll need to be re-run
+type rebuildSteps struct {
+	regenMd   bool
+	templates bool
+	articles  bool
+	netlify   bool
+}
+
+var rebuildEverything = rebuildSteps{
+	regenMd:   true,
+	templates: true,
+	articles:  true,
+	netlify:   true,
+}
+
+func (s *rebuildSteps) add(other rebuildSteps) {
+	s.regenMd = s.regenMd || other.regenMd
+	s.templates = s.templates || other.templates
+	s.articles = s.articles || other.articles
+	s.netlify = s.netlify || other.netlify
+}
+
+// stepsForChangedFile returns parts of the build affected by a change to path
+func stepsForChangedFile(path string) rebuildSteps {
+	path = filepath.ToSlash(path)
+	top := strings.Split(path, "/")[0]
+	if top == mdArticlesDir || top == cacheDir {
+		return rebuildSteps{articles: true, netlify: true}
+	}
+	name := filepath.Base(path)
+	if isMarkdownFile(path) || name == "_md.tmpl.html" {
+		return rebuildSteps{regenMd: true, netlify: true}
+	}
+	if name == tmplAnalytics {
+		// also used by _md.tmpl.html
+		return rebuildSteps{regenMd: true, templates: true, netlify: true}
+	}
+	if skipTmplFiles(path) {
+		return rebuildSteps{templates: true, netlify: true}
+	}
+	// everything else in www is copied as is
+	return rebuildSteps{netlify: true}
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// fileSnapshot maps path of a file to its size and modification time
+type fileSnapshot map[string]fileStamp
+
+func takeFileSnapshot(dirs ...string) fileSnapshot {
+	res := fileSnapshot{}
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			// the directory might not exist or a file might have been
+			// deleted while we walk
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			res[path] = fileStamp{
+				size:    info.Size(),
+				modTime: info.ModTime(),
+			}
+			return nil
+		})
+	}
+	return res
+}
+
+// changedFiles returns sorted paths of files that were added, modified or
+// deleted between two snapshots
+func changedFiles(prev, curr fileSnapshot) []string {
+	var res []string
+	for path, st := range curr {
+		prevSt, ok := prev[path]
+		if !ok || prevSt.size != st.size || !prevSt.modTime.Equal(st.modTime) {
+			res = append(res, path)
+		}
+	}
+	for path := range prev {
+		if _, ok := curr[path]; !ok {
+			res = append(res, path)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// runBuildStep runs fn, turning panics from panicIfErr and friends into
+// an error
+func runBuildStep(name string, fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s failed with '%v'", name, r)
+		}
+	}()
+	fn()
+	return nil
+}
+
+type watcher struct {
+	client *notionapi.Client
+	// articles from the last successful load
+	store *Articles
+	files fileSnapshot
+	// true if the last parsing of templates failed. templates still has
+	// the old templates so we can't check for nil
+	templatesBroken bool
+}
+
+func newWatcher(c *notionapi.Client) *watcher {
+	return &watcher{
+		client: c,
+	}
+}
+
+func (w *watcher) reloadArticles() error {
+	var store *Articles
+	offlineResetMissing()
+	err := runBuildStep("loading articles", func() {
+		store = loadArticles(contentSources(w.client))
+		readRedirects(store)
+	})
+	if err != nil {
+		return err
+	}
+	if err = offlineCheckMissing(); err != nil {
+		// not fatal, missing pages are skipped
+		fmt.Printf("%s\n", err)
+	}
+	w.store = store
+	return nil
+}
+
+// rebuild re-runs given parts of the build. It stops at the first error
+// so that we don't write pages with stale templates or articles
+func (w *watcher) rebuild(steps rebuildSteps) {
+	timeStart := time.Now()
+	err := w.rebuildSteps(steps)
+	if err != nil {
+		fmt.Printf("Build failed: %s\nWaiting for changes\n", err)
+	} else {
+		fmt.Printf("Build finished in %s\n", time.Since(timeStart))
+	}
+	// files we wrote ourselves (e.g. .html generated from .md) are
+	// not changes we should react to
+	w.files = takeFileSnapshot(watchDirs...)
+}
+
+func (w *watcher) rebuildSteps(steps rebuildSteps) error {
+	if steps.regenMd {
+		if err := runBuildStep("regenMd", regenMd); err != nil {
+			return err
+		}
+	}
+	if steps.templates || templates == nil || w.templatesBroken {
+		// if parsing fails, templates are not updated but we don't
+		// build with old templates either, until they parse again
+		err := runBuildStep("loadTemplates", loadTemplates)
+		w.templatesBroken = err != nil
+		if err != nil {
+			return err
+		}
+	}
+	if steps.articles || w.store == nil {
+		if err := w.reloadArticles(); err != nil {
+			return err
+		}
+	}
+	if !steps.netlify {
+		return nil
+	}
+	return runBuildStep("netlifyBuild", func() {
+		netlifyBuild(w.store)
+	})
+}
+
+// run polls for changes and never returns
+func (w *watcher) run() {
+	// after the first build we only use what's in notion_cache. We watch
+	// it so re-downloading a page in another process triggers a re-build.
+	// Only the first build can be a clean build
+	flgOffline = true
+	flgCleanBuild = false
+	fmt.Printf("Watching %s for changes\n", strings.Join(watchDirs, ", "))
+	for {
+		time.Sleep(watchInterval)
+		curr := takeFileSnapshot(watchDirs...)
+		changed := changedFiles(w.files, curr)
+		if len(changed) == 0 {
+			continue
+		}
+		var steps rebuildSteps
+		for _, path := range changed {
+			logVerbose("changed: %s\n", path)
+			steps.add(stepsForChangedFile(path))
+		}
+		fmt.Printf("%d files changed, re-building\n", len(changed))
+		w.rebuild(steps)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStepsForChangedFile(t *testing.T) {
+	tests := []struct {
+		path string
+		exp  rebuildSteps
+	}{
+		{"www/css/main.css", rebuildSteps{netlify: true}},
+		{"www/article.tmpl.html", rebuildSteps{templates: true, netlify: true}},
+		{"www/software/scdiff.md", rebuildSteps{regenMd: true, netlify: true}},
+		{"www/software/_md.tmpl.html", rebuildSteps{regenMd: true, netlify: true}},
+		{"www/analytics.tmpl.html", rebuildSteps{regenMd: true, templates: true, netlify: true}},
+		{"notion_cache/0367c2db381a4f8b9ce360f388a6b2e3.json", rebuildSteps{articles: true, netlify: true}},
+		{"notion_cache/img/foo.png", rebuildSteps{articles: true, netlify: true}},
+		{"articles/hello.md", rebuildSteps{articles: true, netlify: true}},
+	}
+	for _, test := range tests {
+		got := stepsForChangedFile(test.path)
+		assert.Equal(t, test.exp, got, "path: %s", test.path)
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	now := time.Now()
+	prev := fileSnapshot{
+		"a": {size: 1, modTime: now},
+		"b": {size: 1, modTime: now},
+		"c": {size: 1, modTime: now},
+		"d": {size: 1, modTime: now},
+	}
+	curr := fileSnapshot{
+		"a": {size: 1, modTime: now},
+		"b": {size: 2, modTime: now},
+		"c": {size: 1, modTime: now.Add(time.Second)},
+		"e": {size: 1, modTime: now},
+	}
+	assert.Equal(t, []string{"b", "c", "d", "e"}, changedFiles(prev, curr))
+	assert.Nil(t, changedFiles(curr, curr))
+}